@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// HPKE (RFC 9180) base mode with the suite the backend opens:
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-256-GCM
+const (
+	hpkeKEMID        = 0x0020
+	hpkeKDFID        = 0x0001
+	hpkeAEADID       = 0x0002
+	hpkeVersionLabel = "HPKE-v1"
+)
+
+// HPKESealed holds a sealed message in the form POST /api/hpke/open expects (base64 fields)
+type HPKESealed struct {
+	Enc        string `json:"enc"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// HPKESeal encrypts plaintext offline to an X25519 key held by the backend, given the base64
+// public_key from GET /api/keys/{id}/public-key. It needs no session: only the backend can open
+// the result, with the same info and aad.
+func HPKESeal(publicKey string, info, aad, plaintext []byte) (*HPKESealed, error) {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 public key: %w", err)
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 public key: %w", err)
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	dh, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	enc := ephemeral.PublicKey().Bytes()
+
+	// Encap: shared_secret = ExtractAndExpand(dh, enc || pkR)
+	kemSuiteID := binary.BigEndian.AppendUint16([]byte("KEM"), hpkeKEMID)
+	eaePRK := hpkeLabeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	sharedSecret := hpkeLabeledExpand(kemSuiteID, eaePRK, "shared_secret", append(append([]byte{}, enc...), raw...), 32)
+
+	// KeySchedule in base mode, without a PSK
+	suiteID := []byte("HPKE")
+	suiteID = binary.BigEndian.AppendUint16(suiteID, hpkeKEMID)
+	suiteID = binary.BigEndian.AppendUint16(suiteID, hpkeKDFID)
+	suiteID = binary.BigEndian.AppendUint16(suiteID, hpkeAEADID)
+	keyScheduleContext := []byte{0x00}
+	keyScheduleContext = append(keyScheduleContext, hpkeLabeledExtract(suiteID, nil, "psk_id_hash", nil)...)
+	keyScheduleContext = append(keyScheduleContext, hpkeLabeledExtract(suiteID, nil, "info_hash", info)...)
+	secret := hpkeLabeledExtract(suiteID, sharedSecret, "secret", nil)
+	key := hpkeLabeledExpand(suiteID, secret, "key", keyScheduleContext, 32)
+	baseNonce := hpkeLabeledExpand(suiteID, secret, "base_nonce", keyScheduleContext, gcmNonceSize)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %w", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create GCM cipher: %w", err)
+	}
+
+	// A single message uses sequence number 0, so the nonce is base_nonce itself
+	return &HPKESealed{
+		Enc:        base64.StdEncoding.EncodeToString(enc),
+		Ciphertext: base64.StdEncoding.EncodeToString(aesgcm.Seal(nil, baseNonce, plaintext, aad)),
+	}, nil
+}
+
+// hpkeLabeledExtract is HKDF-Extract over the labeled input keying material
+func hpkeLabeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(hpkeVersionLabel))
+	mac.Write(suiteID)
+	mac.Write([]byte(label))
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hpkeLabeledExpand is HKDF-Expand over the labeled info, for lengths up to 255 SHA-256 blocks
+func hpkeLabeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	labeledInfo := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeledInfo = append(labeledInfo, hpkeVersionLabel...)
+	labeledInfo = append(labeledInfo, suiteID...)
+	labeledInfo = append(labeledInfo, label...)
+	labeledInfo = append(labeledInfo, info...)
+
+	var out, block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(block)
+		mac.Write(labeledInfo)
+		mac.Write([]byte{counter})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// AliasPrefix marks a key name as an alias rather than a key's own name
+const AliasPrefix = "alias/"
+
+// IsAliasName reports whether name refers to an alias
+func IsAliasName(name string) bool {
+	return strings.HasPrefix(name, AliasPrefix)
+}
+
+// UpsertAlias points an alias at a key, creating the alias if it doesn't exist yet.
+// Retargeting happens in a single statement, so concurrent readers see either the old or the new key.
+// Returns sql.ErrNoRows if the target key doesn't exist or isn't owned by the user.
+func UpsertAlias(alias *KeyAlias) error {
+	query := `
+	INSERT INTO key_aliases (user_id, name, key_id)
+	SELECT $1, $2, id FROM keys WHERE id = $3 AND user_id = $1
+	ON CONFLICT (user_id, name) DO UPDATE SET key_id = EXCLUDED.key_id, updated_at = CURRENT_TIMESTAMP
+	RETURNING id, created_at, updated_at`
+	err := DB.QueryRow(query, alias.UserID, alias.Name, alias.KeyID).Scan(&alias.ID, &alias.CreatedAt, &alias.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows // Target key not found for this user
+		}
+		return fmt.Errorf("failed to upsert alias: %w", err)
+	}
+	return nil
+}
+
+// GetAliasByName retrieves an alias by its name and user ID
+func GetAliasByName(name string, userID int) (*KeyAlias, error) {
+	alias := &KeyAlias{}
+	query := `SELECT id, user_id, name, key_id, created_at, updated_at FROM key_aliases WHERE name = $1 AND user_id = $2`
+	err := DB.QueryRow(query, name, userID).Scan(&alias.ID, &alias.UserID, &alias.Name, &alias.KeyID, &alias.CreatedAt, &alias.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Alias not found for this user
+		}
+		return nil, fmt.Errorf("failed to get alias by name: %w", err)
+	}
+	return alias, nil
+}
+
+// GetAllAliasesForUser retrieves all aliases for a specific user
+func GetAllAliasesForUser(userID int) ([]KeyAlias, error) {
+	rows, err := DB.Query(`SELECT id, user_id, name, key_id, created_at, updated_at FROM key_aliases WHERE user_id = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all aliases for user: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := []KeyAlias{}
+	for rows.Next() {
+		alias := KeyAlias{}
+		if err := rows.Scan(&alias.ID, &alias.UserID, &alias.Name, &alias.KeyID, &alias.CreatedAt, &alias.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alias row: %w", err)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+// DeleteAlias deletes an alias by its name and user ID. The key it points at is untouched.
+func DeleteAlias(name string, userID int) error {
+	result, err := DB.Exec(`DELETE FROM key_aliases WHERE name = $1 AND user_id = $2`, name, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Alias not found for delete
+	}
+	return nil
+}
+
+// ResolveKey retrieves a key by name or by alias ("alias/..."), scoped to the user.
+// Use it wherever an API accepts a key name.
+func ResolveKey(nameOrAlias string, userID int) (*Key, error) {
+	if !IsAliasName(nameOrAlias) {
+		return GetKeyByName(nameOrAlias, userID)
+	}
+
+	key := &Key{}
+	query := `SELECT ` + prefixColumns("k", keyColumns) + ` FROM key_aliases a JOIN keys k ON k.id = a.key_id WHERE a.name = $1 AND a.user_id = $2`
+	err := scanKey(DB.QueryRow(query, nameOrAlias, userID), key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Alias not found for this user
+		}
+		return nil, fmt.Errorf("failed to resolve key alias: %w", err)
+	}
+	return key, nil
+}
+
+// prefixColumns qualifies each column in a comma-separated column list with a table alias
+func prefixColumns(table, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, column := range parts {
+		parts[i] = table + "." + strings.TrimSpace(column)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// CreateApp inserts a new app into the database
+func CreateApp(app *App) error {
+	query := `INSERT INTO apps (name, description) VALUES ($1, $2) RETURNING id, created_at`
+	err := DB.QueryRow(query, app.Name, app.Description).Scan(&app.ID, &app.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create app: %w", err)
+	}
+	return nil
+}
+
+// GetApp retrieves an app by ID
+func GetApp(id int) (*App, error) {
+	return getApp(`SELECT id, name, description, created_at FROM apps WHERE id = $1`, id)
+}
+
+// GetAppByName retrieves an app by name
+func GetAppByName(name string) (*App, error) {
+	return getApp(`SELECT id, name, description, created_at FROM apps WHERE name = $1`, name)
+}
+
+func getApp(query string, args ...interface{}) (*App, error) {
+	app := &App{}
+	err := DB.QueryRow(query, args...).Scan(&app.ID, &app.Name, &app.Description, &app.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // App not found
+		}
+		return nil, fmt.Errorf("failed to get app: %w", err)
+	}
+	return app, nil
+}
+
+// GetApps retrieves all registered apps
+func GetApps() ([]App, error) {
+	rows, err := DB.Query(`SELECT id, name, description, created_at FROM apps ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get apps: %w", err)
+	}
+	defer rows.Close()
+
+	apps := []App{}
+	for rows.Next() {
+		app := App{}
+		if err := rows.Scan(&app.ID, &app.Name, &app.Description, &app.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan app row: %w", err)
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// DeleteApp deletes an app, together with its registration tokens
+func DeleteApp(id int) error {
+	result, err := DB.Exec(`DELETE FROM apps WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete app: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // App not found for delete
+	}
+	return nil
+}
+
+// appRegistrationTokenColumns lists the columns scanned by scanAppRegistrationToken, in order
+const appRegistrationTokenColumns = `id, app_id, prefix, secret_hash, expires_at, last_used_at, created_at`
+
+func scanAppRegistrationToken(row rowScanner, token *AppRegistrationToken) error {
+	return row.Scan(&token.ID, &token.AppID, &token.Prefix, &token.SecretHash, &token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt)
+}
+
+// CreateAppRegistrationToken inserts a new registration token into the database
+func CreateAppRegistrationToken(token *AppRegistrationToken) error {
+	query := `INSERT INTO app_registration_tokens (app_id, prefix, secret_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	err := DB.QueryRow(query, token.AppID, token.Prefix, token.SecretHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create app registration token: %w", err)
+	}
+	return nil
+}
+
+// GetAppRegistrationTokenByPrefix retrieves a registration token by its prefix, for authentication
+func GetAppRegistrationTokenByPrefix(prefix string) (*AppRegistrationToken, error) {
+	token := &AppRegistrationToken{}
+	err := scanAppRegistrationToken(DB.QueryRow(`SELECT `+appRegistrationTokenColumns+` FROM app_registration_tokens WHERE prefix = $1`, prefix), token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Registration token not found
+		}
+		return nil, fmt.Errorf("failed to get app registration token: %w", err)
+	}
+	return token, nil
+}
+
+// GetAppRegistrationTokens retrieves all registration tokens of an app
+func GetAppRegistrationTokens(appID int) ([]AppRegistrationToken, error) {
+	rows, err := DB.Query(`SELECT `+appRegistrationTokenColumns+` FROM app_registration_tokens WHERE app_id = $1 ORDER BY created_at`, appID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get app registration tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []AppRegistrationToken{}
+	for rows.Next() {
+		token := AppRegistrationToken{}
+		if err := scanAppRegistrationToken(rows, &token); err != nil {
+			return nil, fmt.Errorf("failed to scan app registration token row: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// DeleteAppRegistrationToken deletes one of an app's registration tokens, revoking it
+func DeleteAppRegistrationToken(id, appID int) error {
+	result, err := DB.Exec(`DELETE FROM app_registration_tokens WHERE id = $1 AND app_id = $2`, id, appID)
+	if err != nil {
+		return fmt.Errorf("failed to delete app registration token: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Registration token not found for delete
+	}
+	return nil
+}
+
+// TouchAppRegistrationToken records that a registration token was just used, at most once a minute like TouchAPIKey
+func TouchAppRegistrationToken(id int) error {
+	_, err := DB.Exec(`UPDATE app_registration_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')`, id)
+	if err != nil {
+		return fmt.Errorf("failed to record app registration token use: %w", err)
+	}
+	return nil
+}
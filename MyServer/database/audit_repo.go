@@ -0,0 +1,81 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CreateAuditEvent inserts a new audit event into the database
+func CreateAuditEvent(event *AuditEvent) error {
+	query := `INSERT INTO audit_logs (user_id, service_account_id, action, method, path, status, remote_addr) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	err := DB.QueryRow(query, event.UserID, event.ServiceAccountID, event.Action, event.Method, event.Path, event.Status, event.RemoteAddr).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create audit event: %w", err)
+	}
+	return nil
+}
+
+// AuditLogOptions filters and pages an audit log listing. Events are returned newest first.
+type AuditLogOptions struct {
+	UserID *int
+	Action string
+	Since  *time.Time
+	Until  *time.Time
+	Limit  int
+	// BeforeID is the cursor: only events older than this ID are returned
+	BeforeID int64
+}
+
+// ListAuditEvents retrieves audit events matching opts, newest first.
+// hasMore reports whether further events exist beyond the returned page.
+func ListAuditEvents(opts AuditLogOptions) (events []AuditEvent, hasMore bool, err error) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	addArg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if opts.UserID != nil {
+		conditions = append(conditions, "user_id = "+addArg(*opts.UserID))
+	}
+	if opts.Action != "" {
+		conditions = append(conditions, "action = "+addArg(opts.Action))
+	}
+	if opts.Since != nil {
+		conditions = append(conditions, "created_at >= "+addArg(*opts.Since))
+	}
+	if opts.Until != nil {
+		conditions = append(conditions, "created_at < "+addArg(*opts.Until))
+	}
+	if opts.BeforeID != 0 {
+		conditions = append(conditions, "id < "+addArg(opts.BeforeID))
+	}
+
+	query := fmt.Sprintf(`SELECT id, user_id, service_account_id, action, method, path, status, remote_addr, created_at
+		FROM audit_logs WHERE %s ORDER BY id DESC LIMIT %s`, strings.Join(conditions, " AND "), addArg(opts.Limit+1))
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events = []AuditEvent{}
+	for rows.Next() {
+		event := AuditEvent{}
+		if err := rows.Scan(&event.ID, &event.UserID, &event.ServiceAccountID, &event.Action, &event.Method, &event.Path,
+			&event.Status, &event.RemoteAddr, &event.CreatedAt); err != nil {
+			return nil, false, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	if len(events) > opts.Limit {
+		return events[:opts.Limit], true, nil
+	}
+	return events, false, nil
+}
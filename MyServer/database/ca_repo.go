@@ -0,0 +1,247 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CreateCertificateAuthority marks a key as a CA with the given certificate
+func CreateCertificateAuthority(ca *CertificateAuthority) error {
+	query := `INSERT INTO certificate_authorities (key_id, user_id, key_version, parent_key_id, certificate)
+	VALUES ($1, $2, $3, $4, $5) RETURNING crl_number, created_at`
+	err := DB.QueryRow(query, ca.KeyID, ca.UserID, ca.KeyVersion, ca.ParentKeyID, ca.Certificate).Scan(&ca.CRLNumber, &ca.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate authority: %w", err)
+	}
+	return nil
+}
+
+// GetCertificateAuthority retrieves the CA backed by the given key, regardless of owner.
+// Use GetCertificateAuthorityForUser for anything but public endpoints.
+func GetCertificateAuthority(keyID int) (*CertificateAuthority, error) {
+	return getCertificateAuthority(`SELECT key_id, user_id, key_version, parent_key_id, certificate, crl_number, created_at
+	FROM certificate_authorities WHERE key_id = $1`, keyID)
+}
+
+// GetCertificateAuthorityForUser retrieves the CA backed by the given key and owned by the user
+func GetCertificateAuthorityForUser(keyID, userID int) (*CertificateAuthority, error) {
+	return getCertificateAuthority(`SELECT key_id, user_id, key_version, parent_key_id, certificate, crl_number, created_at
+	FROM certificate_authorities WHERE key_id = $1 AND user_id = $2`, keyID, userID)
+}
+
+func getCertificateAuthority(query string, args ...interface{}) (*CertificateAuthority, error) {
+	ca := &CertificateAuthority{}
+	err := DB.QueryRow(query, args...).Scan(&ca.KeyID, &ca.UserID, &ca.KeyVersion, &ca.ParentKeyID, &ca.Certificate, &ca.CRLNumber, &ca.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // CA not found
+		}
+		return nil, fmt.Errorf("failed to get certificate authority: %w", err)
+	}
+	return ca, nil
+}
+
+// GetCachedCRL returns the last CRL signed for a CA and its next update time, or nil if there is none or
+// a certificate has been revoked since
+func GetCachedCRL(keyID int) ([]byte, *time.Time, error) {
+	var (
+		crl        []byte
+		nextUpdate *time.Time
+	)
+	err := DB.QueryRow(`SELECT crl, crl_next_update FROM certificate_authorities WHERE key_id = $1`, keyID).Scan(&crl, &nextUpdate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, nil // CA not found
+		}
+		return nil, nil, fmt.Errorf("failed to get cached CRL: %w", err)
+	}
+	return crl, nextUpdate, nil
+}
+
+// SaveCRL caches a CRL signed with CRL number for a CA, unless a later CRL number has been taken or a
+// certificate revoked since, in which case the CRL may already be outdated
+func SaveCRL(keyID int, number int64, crl []byte, nextUpdate time.Time) error {
+	_, err := DB.Exec(`UPDATE certificate_authorities SET crl = $3, crl_next_update = $4 WHERE key_id = $1 AND crl_number = $2`,
+		keyID, number, crl, nextUpdate)
+	if err != nil {
+		return fmt.Errorf("failed to save CRL: %w", err)
+	}
+	return nil
+}
+
+// NextCRLNumber increments and returns a CA's CRL number, which must grow with every CRL it issues
+func NextCRLNumber(keyID int) (int64, error) {
+	var number int64
+	err := DB.QueryRow(`UPDATE certificate_authorities SET crl_number = crl_number + 1 WHERE key_id = $1 RETURNING crl_number`, keyID).Scan(&number)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bump CRL number: %w", err)
+	}
+	return number, nil
+}
+
+// templateColumns lists the columns scanned by scanTemplate, in order
+const templateColumns = `id, ca_key_id, name, allowed_domains, allow_subdomains, allowed_ip_ranges, allowed_uri_prefixes, key_usages, ext_key_usages, ttl, max_ttl, created_at, updated_at`
+
+func scanTemplate(row rowScanner, t *CertificateTemplate) error {
+	return row.Scan(&t.ID, &t.CAKeyID, &t.Name, pq.Array(&t.AllowedDomains), &t.AllowSubdomains, pq.Array(&t.AllowedIPRanges),
+		pq.Array(&t.AllowedURIPrefixes), pq.Array(&t.KeyUsages), pq.Array(&t.ExtKeyUsages), &t.TTL, &t.MaxTTL, &t.CreatedAt, &t.UpdatedAt)
+}
+
+// UpsertCertificateTemplate creates a CA's template, or replaces it if one with the same name exists
+func UpsertCertificateTemplate(t *CertificateTemplate) error {
+	for _, list := range []*[]string{&t.AllowedDomains, &t.AllowedIPRanges, &t.AllowedURIPrefixes, &t.KeyUsages, &t.ExtKeyUsages} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	query := `
+	INSERT INTO certificate_templates (ca_key_id, name, allowed_domains, allow_subdomains, allowed_ip_ranges, allowed_uri_prefixes, key_usages, ext_key_usages, ttl, max_ttl)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	ON CONFLICT (ca_key_id, name) DO UPDATE SET allowed_domains = EXCLUDED.allowed_domains, allow_subdomains = EXCLUDED.allow_subdomains,
+		allowed_ip_ranges = EXCLUDED.allowed_ip_ranges, allowed_uri_prefixes = EXCLUDED.allowed_uri_prefixes, key_usages = EXCLUDED.key_usages,
+		ext_key_usages = EXCLUDED.ext_key_usages, ttl = EXCLUDED.ttl, max_ttl = EXCLUDED.max_ttl, updated_at = CURRENT_TIMESTAMP
+	RETURNING id, created_at, updated_at`
+	err := DB.QueryRow(query, t.CAKeyID, t.Name, pq.Array(t.AllowedDomains), t.AllowSubdomains, pq.Array(t.AllowedIPRanges), pq.Array(t.AllowedURIPrefixes),
+		pq.Array(t.KeyUsages), pq.Array(t.ExtKeyUsages), t.TTL, t.MaxTTL).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert certificate template: %w", err)
+	}
+	return nil
+}
+
+// GetCertificateTemplate retrieves a CA's template by name
+func GetCertificateTemplate(caKeyID int, name string) (*CertificateTemplate, error) {
+	t := &CertificateTemplate{}
+	err := scanTemplate(DB.QueryRow(`SELECT `+templateColumns+` FROM certificate_templates WHERE ca_key_id = $1 AND name = $2`, caKeyID, name), t)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Template not found
+		}
+		return nil, fmt.Errorf("failed to get certificate template: %w", err)
+	}
+	return t, nil
+}
+
+// GetCertificateTemplates retrieves all templates of a CA
+func GetCertificateTemplates(caKeyID int) ([]CertificateTemplate, error) {
+	rows, err := DB.Query(`SELECT `+templateColumns+` FROM certificate_templates WHERE ca_key_id = $1 ORDER BY name`, caKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []CertificateTemplate{}
+	for rows.Next() {
+		t := CertificateTemplate{}
+		if err := scanTemplate(rows, &t); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate template row: %w", err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// DeleteCertificateTemplate deletes a CA's template. Certificates already issued from it are kept.
+func DeleteCertificateTemplate(caKeyID int, name string) error {
+	result, err := DB.Exec(`DELETE FROM certificate_templates WHERE ca_key_id = $1 AND name = $2`, caKeyID, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate template: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Template not found for delete
+	}
+	return nil
+}
+
+// certificateColumns lists the columns scanned by scanCertificate, in order
+const certificateColumns = `serial_number, ca_key_id, template_name, common_name, dns_names, certificate, not_before, not_after, revoked_at, revocation_reason, created_at`
+
+func scanCertificate(row rowScanner, c *Certificate) error {
+	return row.Scan(&c.SerialNumber, &c.CAKeyID, &c.TemplateName, &c.CommonName, pq.Array(&c.DNSNames), &c.Certificate,
+		&c.NotBefore, &c.NotAfter, &c.RevokedAt, &c.RevocationReason, &c.CreatedAt)
+}
+
+// CreateCertificate records a certificate issued by a CA
+func CreateCertificate(c *Certificate) error {
+	if c.DNSNames == nil {
+		c.DNSNames = []string{}
+	}
+	query := `INSERT INTO certificates (serial_number, ca_key_id, template_name, common_name, dns_names, certificate, not_before, not_after)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created_at`
+	err := DB.QueryRow(query, c.SerialNumber, c.CAKeyID, c.TemplateName, c.CommonName, pq.Array(c.DNSNames), c.Certificate,
+		c.NotBefore, c.NotAfter).Scan(&c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return nil
+}
+
+// GetCertificate retrieves a certificate issued by a CA by its serial number
+func GetCertificate(caKeyID int, serialNumber string) (*Certificate, error) {
+	c := &Certificate{}
+	err := scanCertificate(DB.QueryRow(`SELECT `+certificateColumns+` FROM certificates WHERE ca_key_id = $1 AND serial_number = $2`, caKeyID, serialNumber), c)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Certificate not found
+		}
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+	return c, nil
+}
+
+// GetCertificatesForCA retrieves the certificates issued by a CA, newest first
+func GetCertificatesForCA(caKeyID int) ([]Certificate, error) {
+	return queryCertificates(`SELECT `+certificateColumns+` FROM certificates WHERE ca_key_id = $1 ORDER BY created_at DESC`, caKeyID)
+}
+
+// GetRevokedCertificates retrieves a CA's revoked certificates that haven't expired by now, for its CRL
+func GetRevokedCertificates(caKeyID int, now time.Time) ([]Certificate, error) {
+	return queryCertificates(`SELECT `+certificateColumns+` FROM certificates
+	WHERE ca_key_id = $1 AND revoked_at IS NOT NULL AND not_after > $2 ORDER BY revoked_at`, caKeyID, now)
+}
+
+func queryCertificates(query string, args ...interface{}) ([]Certificate, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificates: %w", err)
+	}
+	defer rows.Close()
+
+	certificates := []Certificate{}
+	for rows.Next() {
+		c := Certificate{}
+		if err := scanCertificate(rows, &c); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate row: %w", err)
+		}
+		certificates = append(certificates, c)
+	}
+	return certificates, nil
+}
+
+// RevokeCertificate marks a certificate as revoked with an RFC 5280 reason code. The CA's cached CRL
+// is dropped and its CRL number bumped, so the next CRL request signs one listing the certificate.
+// Returns sql.ErrNoRows if the CA issued no such certificate or it is already revoked.
+func RevokeCertificate(c *Certificate, reason int) error {
+	query := `
+	WITH revoked AS (
+		UPDATE certificates SET revoked_at = CURRENT_TIMESTAMP, revocation_reason = $1
+		WHERE ca_key_id = $2 AND serial_number = $3 AND revoked_at IS NULL RETURNING revoked_at
+	), outdated AS (
+		UPDATE certificate_authorities SET crl = NULL, crl_next_update = NULL, crl_number = crl_number + 1
+		WHERE key_id = $2 AND EXISTS (SELECT 1 FROM revoked)
+	)
+	SELECT revoked_at FROM revoked`
+	err := DB.QueryRow(query, reason, c.CAKeyID, c.SerialNumber).Scan(&c.RevokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows // Certificate not found or already revoked
+		}
+		return fmt.Errorf("failed to revoke certificate: %w", err)
+	}
+	c.RevocationReason = &reason
+	return nil
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
@@ -36,6 +38,32 @@ func CloseDB() {
 	}
 }
 
+// WithAdvisoryLock runs fn while holding the PostgreSQL session-level advisory lock lockID.
+// If another session already holds the lock, fn is not run and acquired is false.
+// This lets several server instances share background work without doing it twice.
+func WithAdvisoryLock(ctx context.Context, lockID int64, fn func() error) (acquired bool, err error) {
+	// Advisory locks belong to a session, so pin a single connection for lock and unlock
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockID).Scan(&acquired); err != nil {
+		return false, fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+	if !acquired {
+		return false, nil
+	}
+	defer func() {
+		if _, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); unlockErr != nil {
+			log.Printf("Error releasing advisory lock %d: %v", lockID, unlockErr)
+		}
+	}()
+
+	return true, fn()
+}
+
 // createTables creates necessary tables if they don't exist
 func createTables() {
 	userTableSQL := `
@@ -46,6 +74,15 @@ func createTables() {
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	userRoleColumnSQL := `
+	ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user',
+		ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;`
+
+	userPasswordChangeColumnSQL := `
+	ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS password_change_required BOOLEAN NOT NULL DEFAULT FALSE;`
+
 	keyTableSQL := `
 	CREATE TABLE IF NOT EXISTS keys (
 		id SERIAL PRIMARY KEY,
@@ -57,15 +94,422 @@ func createTables() {
 		UNIQUE (user_id, name) -- A user cannot have two keys with the same name
 	);`
 
+	keyRotationColumnsSQL := `
+	ALTER TABLE keys
+		ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1,
+		ADD COLUMN IF NOT EXISTS rotation_period INTEGER NOT NULL DEFAULT 0, -- In days, 0 disables automatic rotation
+		ADD COLUMN IF NOT EXISTS last_rotated_at TIMESTAMP WITH TIME ZONE,
+		ADD COLUMN IF NOT EXISTS next_rotation_at TIMESTAMP WITH TIME ZONE;`
+
+	keyMetadataColumnsSQL := `
+	ALTER TABLE keys
+		ADD COLUMN IF NOT EXISTS algorithm VARCHAR(64) NOT NULL DEFAULT 'AES-256-GCM',
+		ADD COLUMN IF NOT EXISTS state VARCHAR(32) NOT NULL DEFAULT 'enabled',
+		ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}',
+		ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}',
+		ADD COLUMN IF NOT EXISTS scheduled_deletion_at TIMESTAMP WITH TIME ZONE;
+	CREATE INDEX IF NOT EXISTS keys_tags_idx ON keys USING GIN (tags);
+	CREATE INDEX IF NOT EXISTS keys_user_created_idx ON keys (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS keys_user_name_idx ON keys (user_id, name, id);`
+
+	keyValidityColumnsSQL := `
+	ALTER TABLE keys
+		ADD COLUMN IF NOT EXISTS not_before TIMESTAMP WITH TIME ZONE, -- No encryption before this time
+		ADD COLUMN IF NOT EXISTS not_after TIMESTAMP WITH TIME ZONE;  -- No encryption after this time`
+
+	keyPublishColumnSQL := `
+	ALTER TABLE keys
+		ADD COLUMN IF NOT EXISTS publish BOOLEAN NOT NULL DEFAULT false; -- Listed in the JWKS endpoints
+	CREATE INDEX IF NOT EXISTS keys_published_idx ON keys (user_id) WHERE publish;`
+
+	keyVersionTableSQL := `
+	CREATE TABLE IF NOT EXISTS key_versions (
+		key_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		key_material BYTEA NOT NULL, -- Material of a rotated-out version, kept for decryption
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		retired_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (key_id, version),
+		FOREIGN KEY (key_id) REFERENCES keys(id) ON DELETE CASCADE
+	);`
+
+	keyAliasTableSQL := `
+	CREATE TABLE IF NOT EXISTS key_aliases (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		name VARCHAR(255) NOT NULL, -- Always starts with "alias/"
+		key_id INTEGER NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (key_id) REFERENCES keys(id) ON DELETE CASCADE,
+		UNIQUE (user_id, name)
+	);`
+
+	// A CA is pinned to the key version its certificate certifies, so rotating the key doesn't break the CA
+	certificateAuthorityTableSQL := `
+	CREATE TABLE IF NOT EXISTS certificate_authorities (
+		key_id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		key_version INTEGER NOT NULL,
+		parent_key_id INTEGER, -- NULL for a root CA
+		certificate BYTEA NOT NULL, -- DER
+		crl_number BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (key_id) REFERENCES keys(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (parent_key_id) REFERENCES certificate_authorities(key_id) ON DELETE CASCADE -- An intermediate dies with its issuer
+	);`
+
+	certificateAuthorityCRLColumnsSQL := `
+	ALTER TABLE certificate_authorities
+		ADD COLUMN IF NOT EXISTS crl BYTEA, -- DER of the last CRL signed with crl_number, NULL once outdated
+		ADD COLUMN IF NOT EXISTS crl_next_update TIMESTAMP WITH TIME ZONE;`
+
+	certificateTemplateTableSQL := `
+	CREATE TABLE IF NOT EXISTS certificate_templates (
+		id SERIAL PRIMARY KEY,
+		ca_key_id INTEGER NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		allowed_domains TEXT[] NOT NULL DEFAULT '{}',
+		allow_subdomains BOOLEAN NOT NULL DEFAULT false,
+		allowed_ip_ranges TEXT[] NOT NULL DEFAULT '{}', -- CIDRs
+		allowed_uri_prefixes TEXT[] NOT NULL DEFAULT '{}',
+		key_usages TEXT[] NOT NULL DEFAULT '{}',
+		ext_key_usages TEXT[] NOT NULL DEFAULT '{}',
+		ttl INTEGER NOT NULL, -- Default certificate lifetime, in seconds
+		max_ttl INTEGER NOT NULL, -- Longest lifetime a request may ask for, in seconds
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (ca_key_id) REFERENCES certificate_authorities(key_id) ON DELETE CASCADE,
+		UNIQUE (ca_key_id, name)
+	);`
+
+	certificateTemplateIPRangesColumnsSQL := `
+	ALTER TABLE certificate_templates
+		ADD COLUMN IF NOT EXISTS allowed_ip_ranges TEXT[] NOT NULL DEFAULT '{}',
+		DROP COLUMN IF EXISTS allow_ip_sans; -- Allowed any IP SAN, replaced by allowed_ip_ranges`
+
+	certificateTableSQL := `
+	CREATE TABLE IF NOT EXISTS certificates (
+		serial_number VARCHAR(64) PRIMARY KEY, -- Lowercase hex
+		ca_key_id INTEGER NOT NULL,
+		template_name VARCHAR(255) NOT NULL,
+		common_name TEXT NOT NULL,
+		dns_names TEXT[] NOT NULL DEFAULT '{}',
+		certificate BYTEA NOT NULL, -- DER
+		not_before TIMESTAMP WITH TIME ZONE NOT NULL,
+		not_after TIMESTAMP WITH TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		revocation_reason INTEGER, -- RFC 5280 CRLReason code
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (ca_key_id) REFERENCES certificate_authorities(key_id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS certificates_ca_created_idx ON certificates (ca_key_id, created_at);
+	CREATE INDEX IF NOT EXISTS certificates_revoked_idx ON certificates (ca_key_id, not_after) WHERE revoked_at IS NOT NULL;`
+
+	sshRoleTableSQL := `
+	CREATE TABLE IF NOT EXISTS ssh_roles (
+		id SERIAL PRIMARY KEY,
+		key_id INTEGER NOT NULL, -- The Ed25519 key acting as SSH CA
+		name VARCHAR(255) NOT NULL,
+		cert_type VARCHAR(16) NOT NULL, -- "user" or "host"
+		allowed_principals TEXT[] NOT NULL DEFAULT '{}', -- Glob patterns
+		default_principals TEXT[] NOT NULL DEFAULT '{}',
+		critical_options JSONB NOT NULL DEFAULT '{}',
+		extensions JSONB NOT NULL DEFAULT '{}',
+		ttl INTEGER NOT NULL, -- Default certificate lifetime, in seconds
+		max_ttl INTEGER NOT NULL, -- Longest lifetime a request may ask for, in seconds
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (key_id) REFERENCES keys(id) ON DELETE CASCADE,
+		UNIQUE (key_id, name)
+	);`
+
+	sessionTableSQL := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id VARCHAR(32) PRIMARY KEY, -- Random hex, the jti of the session's access tokens
+		user_id INTEGER NOT NULL,
+		refresh_token_hash BYTEA UNIQUE NOT NULL, -- SHA-256 of the current refresh token
+		previous_refresh_token_hash BYTEA, -- SHA-256 of the refresh token it replaced, to detect reuse
+		user_agent TEXT NOT NULL DEFAULT '',
+		remote_addr VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id, created_at);
+	CREATE INDEX IF NOT EXISTS sessions_previous_refresh_idx ON sessions (previous_refresh_token_hash);`
+
+	sessionSigningKeyTableSQL := `
+	CREATE TABLE IF NOT EXISTS session_signing_keys (
+		kid VARCHAR(64) PRIMARY KEY,
+		algorithm VARCHAR(32) NOT NULL,
+		private_key BYTEA NOT NULL, -- PKCS#8
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		activates_at TIMESTAMP WITH TIME ZONE NOT NULL, -- When the key starts signing
+		expires_at TIMESTAMP WITH TIME ZONE -- When the key stops verifying; NULL until a successor is created
+	);`
+
+	serviceAccountTableSQL := `
+	CREATE TABLE IF NOT EXISTS service_accounts (
+		id SERIAL PRIMARY KEY,
+		owner_id INTEGER NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+		UNIQUE (owner_id, name)
+	);`
+
+	apiKeyTableSQL := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		service_account_id INTEGER NOT NULL,
+		prefix VARCHAR(32) UNIQUE NOT NULL, -- Public part of the key, used to look it up
+		secret_hash BYTEA NOT NULL, -- SHA-256 of the secret part
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		expires_at TIMESTAMP WITH TIME ZONE, -- NULL for keys that never expire
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		last_used_ip VARCHAR(255),
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (service_account_id) REFERENCES service_accounts(id) ON DELETE CASCADE
+	);`
+
+	loginThrottleTableSQL := `
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		throttle_key VARCHAR(300) PRIMARY KEY, -- "username:<name>" or "ip:<address>"
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		locked_until TIMESTAMP WITH TIME ZONE -- Logins are refused until then
+	);`
+
+	userTOTPTableSQL := `
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id INTEGER PRIMARY KEY,
+		secret BYTEA NOT NULL,
+		confirmed_at TIMESTAMP WITH TIME ZONE, -- NULL while enrollment is pending
+		last_used_step BIGINT NOT NULL DEFAULT 0, -- Time step of the last accepted code, against replays
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	mfaRecoveryCodeTableSQL := `
+	CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		code_hash BYTEA UNIQUE NOT NULL, -- SHA-256 of the recovery code
+		used_at TIMESTAMP WITH TIME ZONE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	mfaChallengeTableSQL := `
+	CREATE TABLE IF NOT EXISTS mfa_challenges (
+		token_hash BYTEA PRIMARY KEY, -- SHA-256 of the challenge token
+		user_id INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	appTableSQL := `
+	CREATE TABLE IF NOT EXISTS apps (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	appRegistrationTokenTableSQL := `
+	CREATE TABLE IF NOT EXISTS app_registration_tokens (
+		id SERIAL PRIMARY KEY,
+		app_id INTEGER NOT NULL,
+		prefix VARCHAR(32) UNIQUE NOT NULL, -- Public part of the token, used to look it up
+		secret_hash BYTEA NOT NULL, -- SHA-256 of the secret part
+		expires_at TIMESTAMP WITH TIME ZONE, -- NULL for tokens that never expire
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (app_id) REFERENCES apps(id) ON DELETE CASCADE
+	);`
+
+	// audit_logs deliberately has no foreign key on user_id, so the trail survives user deletion
+	auditLogTableSQL := `
+	CREATE TABLE IF NOT EXISTS audit_logs (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER,
+		action VARCHAR(64) NOT NULL,
+		method VARCHAR(16) NOT NULL,
+		path TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		remote_addr VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS audit_logs_created_idx ON audit_logs (created_at);
+	CREATE INDEX IF NOT EXISTS audit_logs_user_idx ON audit_logs (user_id, id);`
+
+	auditLogServiceAccountColumnSQL := `
+	ALTER TABLE audit_logs
+		ADD COLUMN IF NOT EXISTS service_account_id INTEGER;`
+
 	_, err := DB.Exec(userTableSQL)
 	if err != nil {
 		log.Fatalf("Error creating users table: %v", err)
 	}
 	log.Println("Users table checked/created.")
 
+	_, err = DB.Exec(userRoleColumnSQL)
+	if err != nil {
+		log.Fatalf("Error adding role and token_version columns to users table: %v", err)
+	}
+
+	_, err = DB.Exec(userPasswordChangeColumnSQL)
+	if err != nil {
+		log.Fatalf("Error adding password_change_required column to users table: %v", err)
+	}
+
 	_, err = DB.Exec(keyTableSQL)
 	if err != nil {
 		log.Fatalf("Error creating keys table: %v", err)
 	}
 	log.Println("Keys table checked/created.")
-}
\ No newline at end of file
+
+	_, err = DB.Exec(keyRotationColumnsSQL)
+	if err != nil {
+		log.Fatalf("Error adding rotation columns to keys table: %v", err)
+	}
+
+	_, err = DB.Exec(keyMetadataColumnsSQL)
+	if err != nil {
+		log.Fatalf("Error adding metadata columns to keys table: %v", err)
+	}
+
+	_, err = DB.Exec(keyValidityColumnsSQL)
+	if err != nil {
+		log.Fatalf("Error adding validity columns to keys table: %v", err)
+	}
+
+	_, err = DB.Exec(keyPublishColumnSQL)
+	if err != nil {
+		log.Fatalf("Error adding publish column to keys table: %v", err)
+	}
+
+	_, err = DB.Exec(keyVersionTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating key_versions table: %v", err)
+	}
+	log.Println("Key versions table checked/created.")
+
+	_, err = DB.Exec(keyAliasTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating key_aliases table: %v", err)
+	}
+	log.Println("Key aliases table checked/created.")
+
+	_, err = DB.Exec(certificateAuthorityTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating certificate_authorities table: %v", err)
+	}
+	log.Println("Certificate authorities table checked/created.")
+
+	_, err = DB.Exec(certificateAuthorityCRLColumnsSQL)
+	if err != nil {
+		log.Fatalf("Error adding CRL columns to certificate_authorities table: %v", err)
+	}
+
+	_, err = DB.Exec(certificateTemplateTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating certificate_templates table: %v", err)
+	}
+	log.Println("Certificate templates table checked/created.")
+
+	_, err = DB.Exec(certificateTemplateIPRangesColumnsSQL)
+	if err != nil {
+		log.Fatalf("Error adding IP range columns to certificate_templates table: %v", err)
+	}
+
+	_, err = DB.Exec(certificateTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating certificates table: %v", err)
+	}
+	log.Println("Certificates table checked/created.")
+
+	_, err = DB.Exec(sshRoleTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating ssh_roles table: %v", err)
+	}
+	log.Println("SSH roles table checked/created.")
+
+	_, err = DB.Exec(sessionTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating sessions table: %v", err)
+	}
+	log.Println("Sessions table checked/created.")
+
+	_, err = DB.Exec(sessionSigningKeyTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating session_signing_keys table: %v", err)
+	}
+	log.Println("Session signing keys table checked/created.")
+
+	_, err = DB.Exec(serviceAccountTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating service_accounts table: %v", err)
+	}
+	log.Println("Service accounts table checked/created.")
+
+	_, err = DB.Exec(apiKeyTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating api_keys table: %v", err)
+	}
+	log.Println("API keys table checked/created.")
+
+	_, err = DB.Exec(loginThrottleTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating login_throttles table: %v", err)
+	}
+	log.Println("Login throttles table checked/created.")
+
+	_, err = DB.Exec(userTOTPTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating user_totp table: %v", err)
+	}
+	log.Println("User TOTP table checked/created.")
+
+	_, err = DB.Exec(mfaRecoveryCodeTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating mfa_recovery_codes table: %v", err)
+	}
+	log.Println("MFA recovery codes table checked/created.")
+
+	_, err = DB.Exec(mfaChallengeTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating mfa_challenges table: %v", err)
+	}
+	log.Println("MFA challenges table checked/created.")
+
+	_, err = DB.Exec(appTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating apps table: %v", err)
+	}
+	log.Println("Apps table checked/created.")
+
+	_, err = DB.Exec(appRegistrationTokenTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating app_registration_tokens table: %v", err)
+	}
+	log.Println("App registration tokens table checked/created.")
+
+	_, err = DB.Exec(auditLogTableSQL)
+	if err != nil {
+		log.Fatalf("Error creating audit_logs table: %v", err)
+	}
+	log.Println("Audit logs table checked/created.")
+
+	_, err = DB.Exec(auditLogServiceAccountColumnSQL)
+	if err != nil {
+		log.Fatalf("Error adding service_account_id column to audit_logs table: %v", err)
+	}
+}
@@ -3,12 +3,59 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// keyMetadataColumns lists the columns scanned by scanKeyMetadata, in order.
+// It leaves out key_material so listings never load secrets they don't need.
+const keyMetadataColumns = `id, user_id, name, algorithm, state, description, tags, labels, version, rotation_period, last_rotated_at, next_rotation_at, scheduled_deletion_at, not_before, not_after, publish, created_at`
+
+// keyColumns lists the columns scanned by scanKey, in order
+const keyColumns = keyMetadataColumns + `, key_material`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// keyMetadataDest returns the scan destinations matching keyMetadataColumns
+func keyMetadataDest(key *Key) []interface{} {
+	return []interface{}{&key.ID, &key.UserID, &key.Name, &key.Algorithm, &key.State, &key.Description,
+		pq.Array(&key.Tags), &key.Labels, &key.Version, &key.RotationPeriod, &key.LastRotatedAt, &key.NextRotationAt, &key.ScheduledDeletionAt, &key.NotBefore, &key.NotAfter, &key.Publish, &key.CreatedAt}
+}
+
+// scanKeyMetadata scans a row selected with keyMetadataColumns into a Key, leaving KeyMaterial empty
+func scanKeyMetadata(row rowScanner, key *Key) error {
+	return row.Scan(keyMetadataDest(key)...)
+}
+
+// scanKey scans a row selected with keyColumns into a Key
+func scanKey(row rowScanner, key *Key) error {
+	return row.Scan(append(keyMetadataDest(key), &key.KeyMaterial)...)
+}
+
+// nextRotation returns when a key rotated (or created) at from is next due, or nil if rotation is disabled
+func nextRotation(from time.Time, rotationPeriod int) *time.Time {
+	if rotationPeriod <= 0 {
+		return nil
+	}
+	next := from.AddDate(0, 0, rotationPeriod)
+	return &next
+}
+
 // CreateKey inserts a new cryptographic key into the database
 func CreateKey(key *Key) error {
-	query := `INSERT INTO keys (user_id, name, key_material) VALUES ($1, $2, $3) RETURNING id, created_at`
-	err := DB.QueryRow(query, key.UserID, key.Name, key.KeyMaterial).Scan(&key.ID, &key.CreatedAt)
+	if key.Tags == nil {
+		key.Tags = []string{}
+	}
+	key.NextRotationAt = nextRotation(time.Now(), key.RotationPeriod)
+	query := `INSERT INTO keys (user_id, name, algorithm, description, tags, labels, key_material, rotation_period, next_rotation_at, not_before, not_after, publish)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, state, version, created_at`
+	err := DB.QueryRow(query, key.UserID, key.Name, key.Algorithm, key.Description, pq.Array(key.Tags), key.Labels,
+		key.KeyMaterial, key.RotationPeriod, key.NextRotationAt, key.NotBefore, key.NotAfter, key.Publish).Scan(&key.ID, &key.State, &key.Version, &key.CreatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to create key: %w", err)
 	}
@@ -18,8 +65,8 @@ func CreateKey(key *Key) error {
 // GetKeyByID retrieves a key by its ID and user ID
 func GetKeyByID(id, userID int) (*Key, error) {
 	key := &Key{}
-	query := `SELECT id, user_id, name, key_material, created_at FROM keys WHERE id = $1 AND user_id = $2`
-	err := DB.QueryRow(query, id, userID).Scan(&key.ID, &key.UserID, &key.Name, &key.KeyMaterial, &key.CreatedAt)
+	query := `SELECT ` + keyColumns + ` FROM keys WHERE id = $1 AND user_id = $2`
+	err := scanKey(DB.QueryRow(query, id, userID), key)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Key not found for this user
@@ -32,8 +79,8 @@ func GetKeyByID(id, userID int) (*Key, error) {
 // GetKeyByName retrieves a key by its name and user ID
 func GetKeyByName(name string, userID int) (*Key, error) {
 	key := &Key{}
-	query := `SELECT id, user_id, name, key_material, created_at FROM keys WHERE name = $1 AND user_id = $2`
-	err := DB.QueryRow(query, name, userID).Scan(&key.ID, &key.UserID, &key.Name, &key.KeyMaterial, &key.CreatedAt)
+	query := `SELECT ` + keyColumns + ` FROM keys WHERE name = $1 AND user_id = $2`
+	err := scanKey(DB.QueryRow(query, name, userID), key)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Key not found for this user
@@ -43,18 +90,114 @@ func GetKeyByName(name string, userID int) (*Key, error) {
 	return key, nil
 }
 
-// GetAllKeysForUser retrieves all keys for a specific user
-func GetAllKeysForUser(userID int) ([]Key, error) {
-	rows, err := DB.Query(`SELECT id, user_id, name, key_material, created_at FROM keys WHERE user_id = $1`, userID)
+// KeyListOptions controls filtering, sorting and pagination for ListKeysForUser
+type KeyListOptions struct {
+	Tags       []string  // Keys must carry every one of these tags
+	Labels     KeyLabels // Keys must carry every one of these label key/value pairs
+	Algorithm  string
+	State      string
+	NamePrefix string
+
+	SortBy     string // KeySortCreatedAt (default) or KeySortName
+	Descending bool
+	Limit      int
+
+	// AfterValue and AfterID form the cursor: only keys sorting after (AfterValue, AfterID) are returned.
+	// AfterValue is the previous page's last sort value (a name, or an RFC 3339 timestamp).
+	AfterValue string
+	AfterID    int
+}
+
+// Key listing sort fields
+const (
+	KeySortCreatedAt = "created_at"
+	KeySortName      = "name"
+)
+
+// ListKeysForUser retrieves key metadata (without key material) for a specific user, filtered,
+// sorted and limited according to opts. It fetches one extra row so callers can tell whether
+// another page exists: hasMore is true when more keys follow the returned ones.
+func ListKeysForUser(userID int, opts KeyListOptions) (keys []Key, hasMore bool, err error) {
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	addArg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(opts.Tags) > 0 {
+		conditions = append(conditions, "tags @> "+addArg(pq.Array(opts.Tags)))
+	}
+	if len(opts.Labels) > 0 {
+		conditions = append(conditions, "labels @> "+addArg(opts.Labels))
+	}
+	if opts.Algorithm != "" {
+		conditions = append(conditions, "algorithm = "+addArg(opts.Algorithm))
+	}
+	if opts.State != "" {
+		conditions = append(conditions, "state = "+addArg(opts.State))
+	}
+	if opts.NamePrefix != "" {
+		// Escape LIKE wildcards so the prefix is matched literally
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(opts.NamePrefix)
+		conditions = append(conditions, "name LIKE "+addArg(escaped+"%"))
+	}
+
+	sortColumn := KeySortCreatedAt
+	if opts.SortBy == KeySortName {
+		sortColumn = KeySortName
+	}
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if opts.AfterID != 0 {
+		afterValue := addArg(opts.AfterValue)
+		if sortColumn == KeySortCreatedAt {
+			afterValue += "::timestamptz"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", sortColumn, comparison, afterValue, addArg(opts.AfterID)))
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM keys WHERE %s ORDER BY %s %s, id %s LIMIT %s`,
+		keyMetadataColumns, strings.Join(conditions, " AND "), sortColumn, direction, direction, addArg(opts.Limit+1))
+
+	rows, err := DB.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get all keys for user: %w", err)
+		return nil, false, fmt.Errorf("failed to list keys for user: %w", err)
+	}
+	defer rows.Close()
+
+	keys = []Key{}
+	for rows.Next() {
+		key := Key{}
+		if err := scanKeyMetadata(rows, &key); err != nil {
+			return nil, false, fmt.Errorf("failed to scan key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to list keys for user: %w", err)
+	}
+
+	if len(keys) > opts.Limit {
+		return keys[:opts.Limit], true, nil
+	}
+	return keys, false, nil
+}
+
+// GetKeysDueForRotation retrieves all enabled keys, across users, whose next rotation time has passed
+func GetKeysDueForRotation(now time.Time) ([]Key, error) {
+	rows, err := DB.Query(`SELECT `+keyColumns+` FROM keys WHERE state = $1 AND next_rotation_at <= $2 ORDER BY next_rotation_at`, KeyStateEnabled, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys due for rotation: %w", err)
 	}
 	defer rows.Close()
 
 	keys := []Key{}
 	for rows.Next() {
 		key := Key{}
-		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.KeyMaterial, &key.CreatedAt); err != nil {
+		if err := scanKey(rows, &key); err != nil {
 			return nil, fmt.Errorf("failed to scan key row: %w", err)
 		}
 		keys = append(keys, key)
@@ -62,10 +205,23 @@ func GetAllKeysForUser(userID int) ([]Key, error) {
 	return keys, nil
 }
 
-// UpdateKey updates an existing key's name or material
+// UpdateKey updates an existing key's name, metadata, rotation period, validity window or publish flag.
+// Key material is left alone: only RotateKeyMaterial may replace it, so a concurrent rotation isn't undone.
 func UpdateKey(key *Key) error {
-	query := `UPDATE keys SET name = $1, key_material = $2 WHERE id = $3 AND user_id = $4`
-	result, err := DB.Exec(query, key.Name, key.KeyMaterial, key.ID, key.UserID)
+	from := key.CreatedAt
+	if key.LastRotatedAt != nil {
+		from = *key.LastRotatedAt
+	}
+	key.NextRotationAt = nextRotation(from, key.RotationPeriod)
+
+	if key.Tags == nil {
+		key.Tags = []string{}
+	}
+
+	query := `UPDATE keys SET name = $1, description = $2, tags = $3, labels = $4, rotation_period = $5, next_rotation_at = $6,
+	not_before = $7, not_after = $8, publish = $9 WHERE id = $10 AND user_id = $11`
+	result, err := DB.Exec(query, key.Name, key.Description, pq.Array(key.Tags), key.Labels,
+		key.RotationPeriod, key.NextRotationAt, key.NotBefore, key.NotAfter, key.Publish, key.ID, key.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to update key: %w", err)
 	}
@@ -76,16 +232,169 @@ func UpdateKey(key *Key) error {
 	return nil
 }
 
-// DeleteKey deletes a key by its ID and user ID
-func DeleteKey(id, userID int) error {
-	query := `DELETE FROM keys WHERE id = $1 AND user_id = $2`
-	result, err := DB.Exec(query, id, userID)
+// RotateKeyMaterial replaces a key's material with newMaterial and bumps its version.
+// The outgoing material is archived in key_versions so older ciphertexts stay decryptable.
+// On success the key's version and rotation timestamps are updated in place.
+func RotateKeyMaterial(key *Key, newMaterial []byte) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin key rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		version        int
+		material       []byte
+		activeSince    time.Time
+		rotationPeriod int
+	)
+	lockQuery := `SELECT version, key_material, COALESCE(last_rotated_at, created_at), rotation_period FROM keys WHERE id = $1 AND user_id = $2 FOR UPDATE`
+	if err := tx.QueryRow(lockQuery, key.ID, key.UserID).Scan(&version, &material, &activeSince, &rotationPeriod); err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows // Key not found for rotation
+		}
+		return fmt.Errorf("failed to lock key for rotation: %w", err)
+	}
+
+	archiveQuery := `INSERT INTO key_versions (key_id, version, key_material, created_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(archiveQuery, key.ID, version, material, activeSince); err != nil {
+		return fmt.Errorf("failed to archive key version: %w", err)
+	}
+
+	now := time.Now()
+	next := nextRotation(now, rotationPeriod)
+	updateQuery := `UPDATE keys SET key_material = $1, version = $2, last_rotated_at = $3, next_rotation_at = $4 WHERE id = $5`
+	if _, err := tx.Exec(updateQuery, newMaterial, version+1, now, next, key.ID); err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+
+	key.KeyMaterial = newMaterial
+	key.Version = version + 1
+	key.RotationPeriod = rotationPeriod
+	key.LastRotatedAt = &now
+	key.NextRotationAt = next
+	return nil
+}
+
+// GetKeyVersion retrieves a rotated-out version of a key
+func GetKeyVersion(keyID, version int) (*KeyVersion, error) {
+	keyVersion := &KeyVersion{}
+	query := `SELECT key_id, version, key_material, created_at, retired_at FROM key_versions WHERE key_id = $1 AND version = $2`
+	err := DB.QueryRow(query, keyID, version).Scan(&keyVersion.KeyID, &keyVersion.Version, &keyVersion.KeyMaterial, &keyVersion.CreatedAt, &keyVersion.RetiredAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Version not found
+		}
+		return nil, fmt.Errorf("failed to get key version: %w", err)
+	}
+	return keyVersion, nil
+}
+
+// SetKeyState switches a key between the enabled and disabled states.
+// Keys pending deletion are left alone; use CancelKeyDeletion for those.
+// Returns sql.ErrNoRows if no such key is owned by the user or it is pending deletion.
+func SetKeyState(key *Key, state string) error {
+	query := `UPDATE keys SET state = $1 WHERE id = $2 AND user_id = $3 AND state <> $4`
+	result, err := DB.Exec(query, state, key.ID, key.UserID, KeyStatePendingDeletion)
+	if err != nil {
+		return fmt.Errorf("failed to set key state: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Key not found or pending deletion
+	}
+	key.State = state
+	return nil
+}
+
+// ScheduleKeyDeletion moves a key to pending_deletion. Its material is destroyed once deleteAt passes,
+// until then the deletion can be cancelled. Returns sql.ErrNoRows if no such key is owned by the user.
+func ScheduleKeyDeletion(key *Key, deleteAt time.Time) error {
+	query := `UPDATE keys SET state = $1, scheduled_deletion_at = $2 WHERE id = $3 AND user_id = $4`
+	result, err := DB.Exec(query, KeyStatePendingDeletion, deleteAt, key.ID, key.UserID)
 	if err != nil {
-		return fmt.Errorf("failed to delete key: %w", err)
+		return fmt.Errorf("failed to schedule key deletion: %w", err)
 	}
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return sql.ErrNoRows // Key not found for delete
+		return sql.ErrNoRows // Key not found for deletion
 	}
+	key.State = KeyStatePendingDeletion
+	key.ScheduledDeletionAt = &deleteAt
 	return nil
-}
\ No newline at end of file
+}
+
+// CancelKeyDeletion takes a key out of pending_deletion. The key comes back disabled,
+// so it has to be re-enabled explicitly before it is used again.
+// Returns sql.ErrNoRows if no such key is owned by the user or it isn't pending deletion.
+func CancelKeyDeletion(key *Key) error {
+	query := `UPDATE keys SET state = $1, scheduled_deletion_at = NULL WHERE id = $2 AND user_id = $3 AND state = $4`
+	result, err := DB.Exec(query, KeyStateDisabled, key.ID, key.UserID, KeyStatePendingDeletion)
+	if err != nil {
+		return fmt.Errorf("failed to cancel key deletion: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Key not found or not pending deletion
+	}
+	key.State = KeyStateDisabled
+	key.ScheduledDeletionAt = nil
+	return nil
+}
+
+// DestroyKeysDueForDeletion permanently deletes every pending_deletion key whose waiting period has ended,
+// together with its archived versions and aliases. Returns the number of keys destroyed.
+func DestroyKeysDueForDeletion(now time.Time) (int64, error) {
+	query := `DELETE FROM keys WHERE state = $1 AND scheduled_deletion_at <= $2`
+	result, err := DB.Exec(query, KeyStatePendingDeletion, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to destroy keys due for deletion: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
+
+// PublishedKeyVersion is one version of a published key, as listed in a JWKS
+type PublishedKeyVersion struct {
+	KeyID       int
+	Version     int
+	Algorithm   string
+	KeyMaterial []byte
+}
+
+// GetPublishedKeyVersions retrieves the current version of every enabled, published key, plus the
+// versions of those keys rotated out after retiredSince. If userID is nil, keys of all users are returned.
+func GetPublishedKeyVersions(userID *int, retiredSince time.Time) ([]PublishedKeyVersion, error) {
+	args := []interface{}{KeyStateEnabled, retiredSince}
+	ownerCondition := ""
+	if userID != nil {
+		args = append(args, *userID)
+		ownerCondition = " AND k.user_id = $3"
+	}
+
+	query := `SELECT k.id, k.version, k.algorithm, k.key_material FROM keys k
+	WHERE k.publish AND k.state = $1` + ownerCondition + `
+	UNION ALL
+	SELECT k.id, kv.version, k.algorithm, kv.key_material FROM key_versions kv JOIN keys k ON k.id = kv.key_id
+	WHERE k.publish AND k.state = $1 AND kv.retired_at > $2` + ownerCondition + `
+	ORDER BY 1, 2 DESC`
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get published keys: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []PublishedKeyVersion{}
+	for rows.Next() {
+		var v PublishedKeyVersion
+		if err := rows.Scan(&v.KeyID, &v.Version, &v.Algorithm, &v.KeyMaterial); err != nil {
+			return nil, fmt.Errorf("failed to scan published key row: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetLoginThrottle retrieves the failed login count of a username or client address
+func GetLoginThrottle(key string) (*LoginThrottle, error) {
+	t := &LoginThrottle{}
+	query := `SELECT throttle_key, failures, last_failure_at, locked_until FROM login_throttles WHERE throttle_key = $1`
+	err := DB.QueryRow(query, key).Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No recent failures
+		}
+		return nil, fmt.Errorf("failed to get login throttle: %w", err)
+	}
+	return t, nil
+}
+
+// ReserveLoginAttempt counts a login attempt against key before its credentials are checked, so parallel
+// attempts can't all get in ahead of a lockout. Attempts older than forgetBefore are forgotten. Past
+// maxAttempts, the same statement refuses further attempts for backoffBase, doubling with every attempt,
+// up to lockout. It returns key's throttle and true once the attempt is counted, with Failures holding
+// its number, or key's throttle and false if attempts are refused, in which case nothing is counted.
+func ReserveLoginAttempt(key string, forgetBefore time.Time, maxAttempts int, backoffBase, lockout time.Duration) (*LoginThrottle, bool, error) {
+	_, err := DB.Exec(`DELETE FROM login_throttles WHERE last_failure_at < $1
+		AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`, forgetBefore)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to delete stale login throttles: %w", err)
+	}
+
+	// attempt is the number of this attempt, and lockAfter the time attempt n refuses further ones until
+	attempt := `CASE WHEN login_throttles.last_failure_at < $2 THEN 1 ELSE login_throttles.failures + 1 END`
+	lockAfter := func(n string) string {
+		return `CASE WHEN ` + n + ` > $3 THEN CURRENT_TIMESTAMP +
+			LEAST($4 * POWER(2, LEAST(` + n + ` - $3 - 1, 40)), $5) * INTERVAL '1 microsecond' END`
+	}
+	query := `
+	INSERT INTO login_throttles (throttle_key, failures, last_failure_at, locked_until) VALUES ($1, 1, CURRENT_TIMESTAMP, ` + lockAfter("1") + `)
+	ON CONFLICT (throttle_key) DO UPDATE SET
+		failures = ` + attempt + `,
+		last_failure_at = CURRENT_TIMESTAMP,
+		locked_until = ` + lockAfter(attempt) + `
+	WHERE login_throttles.locked_until IS NULL OR login_throttles.locked_until <= CURRENT_TIMESTAMP
+	RETURNING throttle_key, failures, last_failure_at, locked_until`
+	t := &LoginThrottle{}
+	err = DB.QueryRow(query, key, forgetBefore, maxAttempts, backoffBase.Microseconds(), lockout.Microseconds()).
+		Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err == nil {
+		return t, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("failed to reserve login attempt: %w", err)
+	}
+
+	// Nothing was updated, so key is locked
+	t, err = GetLoginThrottle(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if t == nil || t.LockedUntil == nil {
+		return nil, false, fmt.Errorf("failed to reserve login attempt: lockout of %s lifted concurrently", key)
+	}
+	return t, false, nil
+}
+
+// RefundLoginAttempt takes back attempt, as returned by ReserveLoginAttempt, since its credentials were
+// right. The lockout it set is lifted too, unless a later attempt has been made since.
+func RefundLoginAttempt(key string, attempt int) error {
+	query := `UPDATE login_throttles SET failures = failures - 1,
+		locked_until = CASE WHEN failures = $2 THEN NULL ELSE locked_until END
+		WHERE throttle_key = $1 AND failures > 0`
+	if _, err := DB.Exec(query, key, attempt); err != nil {
+		return fmt.Errorf("failed to refund login attempt: %w", err)
+	}
+	return nil
+}
+
+// ClearLoginFailures forgets the failed logins of key, lifting any lockout
+func ClearLoginFailures(key string) error {
+	if _, err := DB.Exec(`DELETE FROM login_throttles WHERE throttle_key = $1`, key); err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
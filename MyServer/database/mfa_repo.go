@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetUserTOTP retrieves a user's TOTP authenticator, pending or confirmed
+func GetUserTOTP(userID int) (*UserTOTP, error) {
+	t := &UserTOTP{}
+	query := `SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM user_totp WHERE user_id = $1`
+	err := DB.QueryRow(query, userID).Scan(&t.UserID, &t.Secret, &t.ConfirmedAt, &t.LastUsedStep, &t.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No TOTP authenticator enrolled
+		}
+		return nil, fmt.Errorf("failed to get TOTP authenticator: %w", err)
+	}
+	return t, nil
+}
+
+// SavePendingUserTOTP starts a TOTP enrollment for a user with the given secret, replacing any pending
+// one. It returns sql.ErrNoRows if the user already has a confirmed authenticator.
+func SavePendingUserTOTP(userID int, secret []byte) error {
+	query := `
+	INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+	WHERE user_totp.confirmed_at IS NULL`
+	result, err := DB.Exec(query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to save TOTP authenticator: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Already confirmed
+	}
+	return nil
+}
+
+// ConfirmUserTOTP enables a user's pending TOTP authenticator, recording step as its last used time step,
+// and replaces the user's recovery codes with the given hashes, all in one transaction. It returns
+// sql.ErrNoRows if no enrollment is pending.
+func ConfirmUserTOTP(userID int, step int64, recoveryCodeHashes [][]byte) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin TOTP confirmation: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to confirm TOTP authenticator: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // No pending enrollment
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec(`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("failed to create recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit TOTP confirmation: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep records that a code for time step was accepted from a user's confirmed authenticator.
+// It returns false if a code for this or a later step was already accepted, so each code works once.
+func UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := DB.Exec(`UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// DeleteUserTOTP removes a user's TOTP authenticator and recovery codes, disabling MFA
+func DeleteUserTOTP(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin TOTP removal: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete TOTP authenticator: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // No TOTP authenticator for delete
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit TOTP removal: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the user's unused recovery code with the given hash as used.
+// It returns false if the user has no such unused code.
+func UseRecoveryCode(userID int, codeHash []byte) (bool, error) {
+	result, err := DB.Exec(`UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
+
+// CountUnusedRecoveryCodes returns how many of a user's recovery codes are still unused
+func CountUnusedRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// CreateMFAChallenge inserts a new MFA login challenge, first deleting every expired one
+func CreateMFAChallenge(c *MFAChallenge) error {
+	if _, err := DB.Exec(`DELETE FROM mfa_challenges WHERE expires_at <= CURRENT_TIMESTAMP`); err != nil {
+		return fmt.Errorf("failed to delete expired MFA challenges: %w", err)
+	}
+
+	_, err := DB.Exec(`INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		c.TokenHash, c.UserID, c.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create MFA challenge: %w", err)
+	}
+	return nil
+}
+
+// AttemptMFAChallenge counts an attempt at the unexpired challenge with the given token hash and returns it,
+// in a single statement so concurrent attempts can't exceed maxAttempts. It returns nil if there is no such
+// challenge or its attempts are used up.
+func AttemptMFAChallenge(tokenHash []byte, maxAttempts int) (*MFAChallenge, error) {
+	c := &MFAChallenge{}
+	query := `
+	UPDATE mfa_challenges SET attempts = attempts + 1
+	WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP AND attempts < $2
+	RETURNING token_hash, user_id, attempts, expires_at`
+	err := DB.QueryRow(query, tokenHash, maxAttempts).Scan(&c.TokenHash, &c.UserID, &c.Attempts, &c.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No usable challenge
+		}
+		return nil, fmt.Errorf("failed to attempt MFA challenge: %w", err)
+	}
+	return c, nil
+}
+
+// DeleteMFAChallenge deletes a challenge once it has been passed, so its token can't be reused
+func DeleteMFAChallenge(tokenHash []byte) error {
+	if _, err := DB.Exec(`DELETE FROM mfa_challenges WHERE token_hash = $1`, tokenHash); err != nil {
+		return fmt.Errorf("failed to delete MFA challenge: %w", err)
+	}
+	return nil
+}
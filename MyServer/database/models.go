@@ -1,32 +1,356 @@
 package database
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
 // User represents a user in the system
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"` // Don't expose password hash in JSON
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"` // Don't expose password hash in JSON
+	Role         string `json:"role"`
+	TokenVersion int    `json:"-"` // Bumped to invalidate every token issued to the user
+	// PasswordChangeRequired is set when the password no longer meets the password policy. Until the
+	// user changes it, their access tokens only work for changing the password.
+	PasswordChangeRequired bool      `json:"password_change_required"`
+	CreatedAt              time.Time `json:"created_at"`
+}
+
+// User roles. Every user manages their own keys and profile; roles grant access beyond that.
+const (
+	RoleUser     = "user"     // No access beyond their own resources
+	RoleOperator = "operator" // Can also look up user accounts
+	RoleAuditor  = "auditor"  // Can also read the audit log
+	RoleAdmin    = "admin"    // Manages users and their roles, and reads the audit log
+)
+
+// IsValidRole reports whether role is one of the user roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleOperator, RoleAuditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// API key scopes. A service account's API key can only call the endpoints its scopes cover.
+const (
+	ScopeKeysRead  = "keys:read"  // Read keys, aliases, public keys, CAs and SSH CAs
+	ScopeKeysWrite = "keys:write" // Create, update and delete keys, aliases, CA templates and SSH roles
+	ScopeCrypto    = "crypto"     // Use keys: encrypt, decrypt, sign, wrap, derive and the like
+)
+
+// IsValidScope reports whether scope is one of the API key scopes
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopeKeysRead, ScopeKeysWrite, ScopeCrypto:
+		return true
+	}
+	return false
+}
+
+// Key states
+const (
+	KeyStateEnabled         = "enabled"          // Usable for crypto operations
+	KeyStateDisabled        = "disabled"         // Metadata kept, crypto operations refused
+	KeyStatePendingDeletion = "pending_deletion" // Crypto operations refused, destroyed at ScheduledDeletionAt
+)
+
+// KeyLabels holds free-form key/value labels attached to a key, stored as JSONB
+type KeyLabels map[string]string
+
+// Value implements driver.Valuer so labels can be written to a JSONB column
+func (l KeyLabels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner so labels can be read from a JSONB column
+func (l *KeyLabels) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into KeyLabels", src)
+	}
+	return json.Unmarshal(data, l)
 }
 
 // Key represents a cryptographic key associated with a user
 type Key struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
-	Name        string    `json:"name"`
-	KeyMaterial []byte    `json:"-"` // Don't expose raw key material in JSON
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	Name           string     `json:"name"`
+	Algorithm      string     `json:"algorithm"`
+	State          string     `json:"state"`
+	Description    string     `json:"description"`
+	Tags           []string   `json:"tags"`
+	Labels         KeyLabels  `json:"labels"`
+	KeyMaterial    []byte     `json:"-"` // Don't expose raw key material in JSON
+	Version        int        `json:"version"`
+	RotationPeriod int        `json:"rotation_period"` // In days; 0 disables automatic rotation
+	LastRotatedAt  *time.Time `json:"last_rotated_at"`
+	NextRotationAt *time.Time `json:"next_rotation_at"`
+	// ScheduledDeletionAt is when a pending_deletion key's material is destroyed
+	ScheduledDeletionAt *time.Time `json:"scheduled_deletion_at"`
+	// NotBefore and NotAfter bound the period in which the key may encrypt (its cryptoperiod)
+	NotBefore *time.Time `json:"not_before"`
+	NotAfter  *time.Time `json:"not_after"`
+	// Publish lists the public half of an asymmetric key in the JWKS endpoints
+	Publish   bool      `json:"publish"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeyVersion holds the material of a key version that has been rotated out.
+// It is kept so that data encrypted under an older version can still be decrypted.
+type KeyVersion struct {
+	KeyID       int       `json:"key_id"`
+	Version     int       `json:"version"`
+	KeyMaterial []byte    `json:"-"`
 	CreatedAt   time.Time `json:"created_at"`
+	RetiredAt   time.Time `json:"retired_at"`
 }
 
-// KeyResponse is used for API responses to avoid exposing raw key material
-type KeyResponse struct {
+// KeyAlias is a user-scoped name (e.g. "alias/payments") that points at one of the user's keys
+// and can be retargeted without changing the applications that use it
+type KeyAlias struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
 	Name      string    `json:"name"`
+	KeyID     int       `json:"key_id"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CertificateAuthority marks a key as a root or intermediate X.509 CA
+type CertificateAuthority struct {
+	KeyID       int       `json:"key_id"`
+	UserID      int       `json:"user_id"`
+	KeyVersion  int       `json:"key_version"`   // The key version whose public key the CA certificate certifies
+	ParentKeyID *int      `json:"parent_key_id"` // Nil for a root CA
+	Certificate []byte    `json:"-"`             // DER
+	CRLNumber   int64     `json:"crl_number"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CertificateTemplate constrains the certificates a CA issues from CSRs: which names they may carry,
+// their key usages, and their lifetime
+type CertificateTemplate struct {
+	ID                 int       `json:"id"`
+	CAKeyID            int       `json:"ca_key_id"`
+	Name               string    `json:"name"`
+	AllowedDomains     []string  `json:"allowed_domains"`
+	AllowSubdomains    bool      `json:"allow_subdomains"`
+	AllowedIPRanges    []string  `json:"allowed_ip_ranges"`    // CIDRs, e.g. "10.0.0.0/16"
+	AllowedURIPrefixes []string  `json:"allowed_uri_prefixes"` // e.g. "spiffe://mesh.internal/"
+	KeyUsages          []string  `json:"key_usages"`           // e.g. "digital_signature", "key_encipherment"
+	ExtKeyUsages       []string  `json:"ext_key_usages"`       // e.g. "server_auth", "client_auth"
+	TTL                int       `json:"ttl"`                  // In seconds
+	MaxTTL             int       `json:"max_ttl"`              // In seconds
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// Certificate is an X.509 certificate issued by a CA
+type Certificate struct {
+	SerialNumber     string     `json:"serial_number"` // Lowercase hex
+	CAKeyID          int        `json:"ca_key_id"`
+	TemplateName     string     `json:"template"`
+	CommonName       string     `json:"common_name"`
+	DNSNames         []string   `json:"dns_names"`
+	Certificate      []byte     `json:"-"` // DER
+	NotBefore        time.Time  `json:"not_before"`
+	NotAfter         time.Time  `json:"not_after"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason *int       `json:"revocation_reason,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// SSH certificate types
+const (
+	SSHCertTypeUser = "user"
+	SSHCertTypeHost = "host"
+)
+
+// SSHOptions holds SSH certificate critical options or extensions, stored as JSONB
+type SSHOptions map[string]string
+
+// Value implements driver.Valuer so options can be written to a JSONB column
+func (o SSHOptions) Value() (driver.Value, error) {
+	return KeyLabels(o).Value()
+}
+
+// Scan implements sql.Scanner so options can be read from a JSONB column
+func (o *SSHOptions) Scan(src interface{}) error {
+	return (*KeyLabels)(o).Scan(src)
+}
+
+// SSHRole controls what an SSH CA key signs: the certificate type, which principals may be
+// requested, the critical options and extensions every certificate carries, and the lifetime
+type SSHRole struct {
+	ID                int        `json:"id"`
+	KeyID             int        `json:"key_id"`
+	Name              string     `json:"name"`
+	CertType          string     `json:"cert_type"`
+	AllowedPrincipals []string   `json:"allowed_principals"` // Glob patterns, e.g. "deploy" or "*.prod.internal"
+	DefaultPrincipals []string   `json:"default_principals"` // Used when a request names no principals
+	CriticalOptions   SSHOptions `json:"critical_options"`   // e.g. force-command, source-address
+	Extensions        SSHOptions `json:"extensions"`         // e.g. permit-pty
+	TTL               int        `json:"ttl"`                // In seconds
+	MaxTTL            int        `json:"max_ttl"`            // In seconds
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// KeyResponse is used for API responses to avoid exposing raw key material
+type KeyResponse struct {
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	Name           string     `json:"name"`
+	Algorithm      string     `json:"algorithm"`
+	State          string     `json:"state"`
+	Description    string     `json:"description,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	Labels         KeyLabels  `json:"labels,omitempty"`
+	Version        int        `json:"version"`
+	RotationPeriod int        `json:"rotation_period"`
+	LastRotatedAt  *time.Time `json:"last_rotated_at,omitempty"`
+	NextRotationAt *time.Time `json:"next_rotation_at,omitempty"`
+	Aliases        []string   `json:"aliases,omitempty"`
+	// ScheduledDeletionAt is set while the key is pending deletion
+	ScheduledDeletionAt *time.Time `json:"scheduled_deletion_at,omitempty"`
+	NotBefore           *time.Time `json:"not_before,omitempty"`
+	NotAfter            *time.Time `json:"not_after,omitempty"`
+	Publish             bool       `json:"publish"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// Session is a login session. Its refresh token is stored only as a SHA-256 hash and is replaced on
+// every refresh; the access tokens issued for the session carry the session ID as their jti.
+type Session struct {
+	ID               string     `json:"id"`
+	UserID           int        `json:"user_id"`
+	RefreshTokenHash []byte     `json:"-"`
+	UserAgent        string     `json:"user_agent"`
+	RemoteAddr       string     `json:"remote_addr"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	ExpiresAt        time.Time  `json:"expires_at"` // The session can't be refreshed past this time
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+// UserTOTP is a user's TOTP authenticator. It is pending until the user confirms it with a valid code;
+// from then on, logging in requires a code as well as the password.
+type UserTOTP struct {
+	UserID       int        `json:"-"`
+	Secret       []byte     `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"` // Nil while enrollment is pending
+	LastUsedStep int64      `json:"-"`                      // Time step of the last accepted code, so no code is accepted twice
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// LoginThrottle counts recent failed logins for a username or client address, and how long further
+// attempts are blocked
+type LoginThrottle struct {
+	Key           string     `json:"key"` // "username:<name>" or "ip:<address>"
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// MFAChallenge is the pending second step of a login by a user with MFA enabled. Its token is
+// stored as a SHA-256 hash.
+type MFAChallenge struct {
+	TokenHash []byte
+	UserID    int
+	Attempts  int // Codes tried against the challenge so far
+	ExpiresAt time.Time
+}
+
+// SessionSigningKey is a key pair that signs the access tokens of login sessions. A key is published in
+// the session JWKS from creation, signs from ActivatesAt until a newer key activates, and keeps
+// verifying the tokens it signed until ExpiresAt.
+type SessionSigningKey struct {
+	KeyID       string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"` // Ed25519 or EC-P256
+	PrivateKey  []byte     `json:"-"`         // PKCS#8
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // Set once a successor is created
+}
+
+// ServiceAccount is a non-human identity owned by a user, for batch workers and other machine clients.
+// Its API keys act on behalf of the owner, limited to their scopes.
+type ServiceAccount struct {
+	ID          int       `json:"id"`
+	OwnerID     int       `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// APIKey is a long-lived credential of a service account, presented as "Authorization: ApiKey <key>".
+// Only its prefix is stored in the clear; the secret part is stored as a SHA-256 hash.
+type APIKey struct {
+	ID               int        `json:"id"`
+	ServiceAccountID int        `json:"service_account_id"`
+	Prefix           string     `json:"prefix"` // Identifies the key in listings and logs
+	SecretHash       []byte     `json:"-"`
+	Scopes           []string   `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"` // Nil for keys that never expire
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP       *string    `json:"last_used_ip,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// App is an application registered to use the SDKs. Its registration tokens let SDK clients fetch
+// their configuration.
+type App struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AppRegistrationToken is the credential an app's SDK clients pass to Init. Like an API key, only
+// its prefix is stored in the clear and the secret part as a SHA-256 hash.
+type AppRegistrationToken struct {
+	ID         int        `json:"id"`
+	AppID      int        `json:"app_id"`
+	Prefix     string     `json:"prefix"` // Identifies the token in listings
+	SecretHash []byte     `json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Nil for tokens that never expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AuditEvent records a single audited API call
+type AuditEvent struct {
+	ID               int64     `json:"id"`
+	UserID           *int      `json:"user_id"`                      // Nil for unauthenticated calls
+	ServiceAccountID *int      `json:"service_account_id,omitempty"` // Set for calls made with an API key
+	Action           string    `json:"action"`
+	Method           string    `json:"method"`
+	Path             string    `json:"path"`
+	Status           int       `json:"status"`
+	RemoteAddr       string    `json:"remote_addr"`
+	CreatedAt        time.Time `json:"created_at"`
 }
 
 // Secret represents a secret associated with a user and a key
@@ -35,4 +359,4 @@ type Secret struct {
 	KeyID     int       `json:"key_id"`
 	Data      []byte    `json:"-"` // Don't expose raw secret data in JSON
 	CreatedAt time.Time `json:"created_at"`
-}
\ No newline at end of file
+}
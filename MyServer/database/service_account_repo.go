@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// CreateServiceAccount inserts a new service account into the database
+func CreateServiceAccount(sa *ServiceAccount) error {
+	query := `INSERT INTO service_accounts (owner_id, name, description) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err := DB.QueryRow(query, sa.OwnerID, sa.Name, sa.Description).Scan(&sa.ID, &sa.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+	return nil
+}
+
+// GetServiceAccount retrieves one of a user's service accounts by ID
+func GetServiceAccount(id, ownerID int) (*ServiceAccount, error) {
+	return getServiceAccount(`SELECT id, owner_id, name, description, created_at FROM service_accounts WHERE id = $1 AND owner_id = $2`, id, ownerID)
+}
+
+// GetServiceAccountByID retrieves a service account by ID, whoever owns it, for authentication
+func GetServiceAccountByID(id int) (*ServiceAccount, error) {
+	return getServiceAccount(`SELECT id, owner_id, name, description, created_at FROM service_accounts WHERE id = $1`, id)
+}
+
+// GetServiceAccountByName retrieves one of a user's service accounts by name
+func GetServiceAccountByName(name string, ownerID int) (*ServiceAccount, error) {
+	return getServiceAccount(`SELECT id, owner_id, name, description, created_at FROM service_accounts WHERE name = $1 AND owner_id = $2`, name, ownerID)
+}
+
+func getServiceAccount(query string, args ...interface{}) (*ServiceAccount, error) {
+	sa := &ServiceAccount{}
+	err := DB.QueryRow(query, args...).Scan(&sa.ID, &sa.OwnerID, &sa.Name, &sa.Description, &sa.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Service account not found
+		}
+		return nil, fmt.Errorf("failed to get service account: %w", err)
+	}
+	return sa, nil
+}
+
+// GetServiceAccounts retrieves all of a user's service accounts
+func GetServiceAccounts(ownerID int) ([]ServiceAccount, error) {
+	rows, err := DB.Query(`SELECT id, owner_id, name, description, created_at FROM service_accounts WHERE owner_id = $1 ORDER BY name`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []ServiceAccount{}
+	for rows.Next() {
+		sa := ServiceAccount{}
+		if err := rows.Scan(&sa.ID, &sa.OwnerID, &sa.Name, &sa.Description, &sa.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan service account row: %w", err)
+		}
+		accounts = append(accounts, sa)
+	}
+	return accounts, nil
+}
+
+// DeleteServiceAccount deletes one of a user's service accounts, together with its API keys
+func DeleteServiceAccount(id, ownerID int) error {
+	result, err := DB.Exec(`DELETE FROM service_accounts WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to delete service account: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Service account not found for delete
+	}
+	return nil
+}
+
+// apiKeyColumns lists the columns scanned by scanAPIKey, in order
+const apiKeyColumns = `id, service_account_id, prefix, secret_hash, scopes, expires_at, last_used_at, last_used_ip, created_at`
+
+func scanAPIKey(row rowScanner, key *APIKey) error {
+	return row.Scan(&key.ID, &key.ServiceAccountID, &key.Prefix, &key.SecretHash, pq.Array(&key.Scopes),
+		&key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.CreatedAt)
+}
+
+// CreateAPIKey inserts a new API key into the database
+func CreateAPIKey(key *APIKey) error {
+	query := `INSERT INTO api_keys (service_account_id, prefix, secret_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := DB.QueryRow(query, key.ServiceAccountID, key.Prefix, key.SecretHash, pq.Array(key.Scopes), key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its prefix, for authentication
+func GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	key := &APIKey{}
+	err := scanAPIKey(DB.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix), key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // API key not found
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+// GetAPIKeys retrieves all API keys of a service account
+func GetAPIKeys(serviceAccountID int) ([]APIKey, error) {
+	rows, err := DB.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE service_account_id = $1 ORDER BY created_at`, serviceAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key := APIKey{}
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, fmt.Errorf("failed to scan API key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey deletes one of a service account's API keys, revoking it
+func DeleteAPIKey(id, serviceAccountID int) error {
+	result, err := DB.Exec(`DELETE FROM api_keys WHERE id = $1 AND service_account_id = $2`, id, serviceAccountID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // API key not found for delete
+	}
+	return nil
+}
+
+// TouchAPIKey records that an API key was just used from ip. To spare a write on every request,
+// the record is only updated once a minute per key.
+func TouchAPIKey(id int, ip string) error {
+	_, err := DB.Exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute' OR last_used_ip <> $2)`, id, ip)
+	if err != nil {
+		return fmt.Errorf("failed to record API key use: %w", err)
+	}
+	return nil
+}
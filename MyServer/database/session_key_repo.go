@@ -0,0 +1,63 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// GetSessionSigningKeys retrieves the session signing keys that haven't expired at now,
+// including keys not yet active, most recently activated first
+func GetSessionSigningKeys(now time.Time) ([]SessionSigningKey, error) {
+	rows, err := DB.Query(`SELECT kid, algorithm, private_key, created_at, activates_at, expires_at
+		FROM session_signing_keys WHERE expires_at IS NULL OR expires_at > $1
+		ORDER BY activates_at DESC`, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []SessionSigningKey{}
+	for rows.Next() {
+		key := SessionSigningKey{}
+		if err := rows.Scan(&key.KeyID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &key.ActivatesAt, &key.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session signing key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// AddSessionSigningKey inserts a new session signing key and, in the same transaction, sets every
+// existing key without an expiry to expire at predecessorsExpireAt
+func AddSessionSigningKey(key *SessionSigningKey, predecessorsExpireAt time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin session signing key rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE session_signing_keys SET expires_at = $1 WHERE expires_at IS NULL`, predecessorsExpireAt); err != nil {
+		return fmt.Errorf("failed to expire session signing keys: %w", err)
+	}
+
+	query := `INSERT INTO session_signing_keys (kid, algorithm, private_key, activates_at) VALUES ($1, $2, $3, $4) RETURNING created_at`
+	if err := tx.QueryRow(query, key.KeyID, key.Algorithm, key.PrivateKey, key.ActivatesAt).Scan(&key.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create session signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session signing key rotation: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSessionSigningKeys deletes the session signing keys that expired before now.
+// It returns the number of keys deleted.
+func DeleteExpiredSessionSigningKeys(now time.Time) (int64, error) {
+	result, err := DB.Exec(`DELETE FROM session_signing_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired session signing keys: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
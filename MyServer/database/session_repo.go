@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// sessionColumns lists the columns scanned by scanSession, in order
+const sessionColumns = `id, user_id, refresh_token_hash, user_agent, remote_addr, created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(row rowScanner, s *Session) error {
+	return row.Scan(&s.ID, &s.UserID, &s.RefreshTokenHash, &s.UserAgent, &s.RemoteAddr,
+		&s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.RevokedAt)
+}
+
+// CreateSession inserts a new session into the database
+func CreateSession(s *Session) error {
+	query := `
+	INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, remote_addr, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING created_at, last_used_at`
+	err := DB.QueryRow(query, s.ID, s.UserID, s.RefreshTokenHash, s.UserAgent, s.RemoteAddr, s.ExpiresAt).
+		Scan(&s.CreatedAt, &s.LastUsedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetSession retrieves a session by ID, whether or not it is still active
+func GetSession(id string) (*Session, error) {
+	s := &Session{}
+	err := scanSession(DB.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, id), s)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Session not found
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return s, nil
+}
+
+// GetActiveSessions retrieves a user's sessions that are neither revoked nor expired, newest first
+func GetActiveSessions(userID int) ([]Session, error) {
+	rows, err := DB.Query(`SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		s := Session{}
+		if err := scanSession(rows, &s); err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// RotateSessionRefreshToken replaces the refresh token of the active session holding oldHash with newHash,
+// in a single statement so a refresh token can be redeemed only once. It returns nil if no active
+// session holds oldHash.
+func RotateSessionRefreshToken(oldHash, newHash []byte, remoteAddr string) (*Session, error) {
+	s := &Session{}
+	query := `
+	UPDATE sessions SET previous_refresh_token_hash = refresh_token_hash, refresh_token_hash = $2,
+		remote_addr = $3, last_used_at = CURRENT_TIMESTAMP
+	WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	RETURNING ` + sessionColumns
+	err := scanSession(DB.QueryRow(query, oldHash, newHash, remoteAddr), s)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No active session holds the refresh token
+		}
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return s, nil
+}
+
+// RevokeSessionByPreviousRefreshToken revokes the active session whose refresh token replaced the one
+// hashed to hash. A replaced refresh token being presented again means it was copied, so the whole
+// session is treated as compromised. It returns the revoked session's ID, or "" if there was none.
+func RevokeSessionByPreviousRefreshToken(hash []byte) (string, error) {
+	var id string
+	err := DB.QueryRow(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL RETURNING id`, hash).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return id, nil
+}
+
+// RevokeSession revokes one of a user's active sessions
+func RevokeSession(id string, userID int) error {
+	result, err := DB.Exec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Session not found or already revoked
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes all of a user's active sessions except exceptID, which may be empty.
+// It returns the number of sessions revoked.
+func RevokeUserSessions(userID int, exceptID string) (int64, error) {
+	result, err := DB.Exec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`, userID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// sshRoleColumns lists the columns scanned by scanSSHRole, in order
+const sshRoleColumns = `id, key_id, name, cert_type, allowed_principals, default_principals, critical_options, extensions, ttl, max_ttl, created_at, updated_at`
+
+func scanSSHRole(row rowScanner, role *SSHRole) error {
+	return row.Scan(&role.ID, &role.KeyID, &role.Name, &role.CertType, pq.Array(&role.AllowedPrincipals), pq.Array(&role.DefaultPrincipals),
+		&role.CriticalOptions, &role.Extensions, &role.TTL, &role.MaxTTL, &role.CreatedAt, &role.UpdatedAt)
+}
+
+// UpsertSSHRole creates an SSH CA key's role, or replaces it if one with the same name exists
+func UpsertSSHRole(role *SSHRole) error {
+	if role.AllowedPrincipals == nil {
+		role.AllowedPrincipals = []string{}
+	}
+	if role.DefaultPrincipals == nil {
+		role.DefaultPrincipals = []string{}
+	}
+	query := `
+	INSERT INTO ssh_roles (key_id, name, cert_type, allowed_principals, default_principals, critical_options, extensions, ttl, max_ttl)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (key_id, name) DO UPDATE SET cert_type = EXCLUDED.cert_type, allowed_principals = EXCLUDED.allowed_principals,
+		default_principals = EXCLUDED.default_principals, critical_options = EXCLUDED.critical_options, extensions = EXCLUDED.extensions,
+		ttl = EXCLUDED.ttl, max_ttl = EXCLUDED.max_ttl, updated_at = CURRENT_TIMESTAMP
+	RETURNING id, created_at, updated_at`
+	err := DB.QueryRow(query, role.KeyID, role.Name, role.CertType, pq.Array(role.AllowedPrincipals), pq.Array(role.DefaultPrincipals),
+		role.CriticalOptions, role.Extensions, role.TTL, role.MaxTTL).Scan(&role.ID, &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert SSH role: %w", err)
+	}
+	return nil
+}
+
+// GetSSHRole retrieves an SSH CA key's role by name
+func GetSSHRole(keyID int, name string) (*SSHRole, error) {
+	role := &SSHRole{}
+	err := scanSSHRole(DB.QueryRow(`SELECT `+sshRoleColumns+` FROM ssh_roles WHERE key_id = $1 AND name = $2`, keyID, name), role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Role not found
+		}
+		return nil, fmt.Errorf("failed to get SSH role: %w", err)
+	}
+	return role, nil
+}
+
+// GetSSHRoles retrieves all roles of an SSH CA key
+func GetSSHRoles(keyID int) ([]SSHRole, error) {
+	rows, err := DB.Query(`SELECT `+sshRoleColumns+` FROM ssh_roles WHERE key_id = $1 ORDER BY name`, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []SSHRole{}
+	for rows.Next() {
+		role := SSHRole{}
+		if err := scanSSHRole(rows, &role); err != nil {
+			return nil, fmt.Errorf("failed to scan SSH role row: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// DeleteSSHRole deletes an SSH CA key's role
+func DeleteSSHRole(keyID int, name string) error {
+	result, err := DB.Exec(`DELETE FROM ssh_roles WHERE key_id = $1 AND name = $2`, keyID, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete SSH role: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Role not found for delete
+	}
+	return nil
+}
@@ -2,13 +2,25 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 )
 
+// ErrLastAdmin is returned when deleting a user would leave no admin
+var ErrLastAdmin = errors.New("cannot delete the last admin")
+
+// userColumns lists the users columns read by scanUser, in order
+const userColumns = `id, username, password_hash, role, token_version, password_change_required, created_at`
+
+// scanUser scans a row selected with userColumns into user
+func scanUser(row rowScanner, user *User) error {
+	return row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.TokenVersion, &user.PasswordChangeRequired, &user.CreatedAt)
+}
+
 // CreateUser inserts a new user into the database
 func CreateUser(user *User) error {
-	query := `INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id, created_at`
-	err := DB.QueryRow(query, user.Username, user.PasswordHash).Scan(&user.ID, &user.CreatedAt)
+	query := `INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id, role, created_at`
+	err := DB.QueryRow(query, user.Username, user.PasswordHash).Scan(&user.ID, &user.Role, &user.CreatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -18,8 +30,8 @@ func CreateUser(user *User) error {
 // GetUserByID retrieves a user by their ID
 func GetUserByID(id int) (*User, error) {
 	user := &User{}
-	query := `SELECT id, username, password_hash, created_at FROM users WHERE id = $1`
-	err := DB.QueryRow(query, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	err := scanUser(DB.QueryRow(query, id), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // User not found
@@ -32,8 +44,8 @@ func GetUserByID(id int) (*User, error) {
 // GetUserByUsername retrieves a user by their username
 func GetUserByUsername(username string) (*User, error) {
 	user := &User{}
-	query := `SELECT id, username, password_hash, created_at FROM users WHERE username = $1`
-	err := DB.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	err := scanUser(DB.QueryRow(query, username), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // User not found
@@ -45,7 +57,7 @@ func GetUserByUsername(username string) (*User, error) {
 
 // GetAllUsers retrieves all users from the database
 func GetAllUsers() ([]User, error) {
-	rows, err := DB.Query(`SELECT id, username, password_hash, created_at FROM users`)
+	rows, err := DB.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all users: %w", err)
 	}
@@ -54,7 +66,7 @@ func GetAllUsers() ([]User, error) {
 	users := []User{}
 	for rows.Next() {
 		user := User{}
-		if err := rows.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt); err != nil {
+		if err := scanUser(rows, &user); err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
 		users = append(users, user)
@@ -76,16 +88,97 @@ func UpdateUser(user *User) error {
 	return nil
 }
 
-// DeleteUser deletes a user by their ID
+// UpdateUserPassword replaces a user's password hash and bumps their token version, so every
+// token issued before the change stops being accepted. It also clears any required password change.
+// It returns the new token version.
+func UpdateUserPassword(id int, passwordHash string) (int, error) {
+	var tokenVersion int
+	query := `UPDATE users SET password_hash = $1, token_version = token_version + 1, password_change_required = FALSE
+		WHERE id = $2 RETURNING token_version`
+	err := DB.QueryRow(query, passwordHash, id).Scan(&tokenVersion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, sql.ErrNoRows // User not found for update
+		}
+		return 0, fmt.Errorf("failed to update user password: %w", err)
+	}
+	return tokenVersion, nil
+}
+
+// RehashUserPassword replaces a user's password hash with a new hash of the same password, e.g. to
+// upgrade its algorithm or parameters. Sessions are left alone, since the password hasn't changed. The
+// hash is only replaced if it is still oldHash, so a concurrent password change isn't undone.
+func RehashUserPassword(id int, oldHash, newHash string) error {
+	result, err := DB.Exec(`UPDATE users SET password_hash = $3 WHERE id = $1 AND password_hash = $2`, id, oldHash, newHash)
+	if err != nil {
+		return fmt.Errorf("failed to rehash user password: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RequireUserPasswordChange flags a user as having to change their password before using the API
+func RequireUserPasswordChange(id int) error {
+	result, err := DB.Exec(`UPDATE users SET password_change_required = TRUE WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to require password change: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUser deletes a user by their ID. It returns ErrLastAdmin rather than delete the only admin; the
+// other admins are locked, so two admins deleting each other can't both succeed.
 func DeleteUser(id int) error {
-	query := `DELETE FROM users WHERE id = $1`
-	result, err := DB.Exec(query, id)
+	query := `
+	DELETE FROM users WHERE id = $1
+	AND NOT (role = $2 AND NOT EXISTS (SELECT 1 FROM users WHERE role = $2 AND id <> $1 FOR UPDATE))`
+	result, err := DB.Exec(query, id, RoleAdmin)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
+		user, err := GetUserByID(id)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		if user != nil {
+			return ErrLastAdmin
+		}
 		return sql.ErrNoRows // User not found for delete
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// UpdateUserRole changes a user's role
+func UpdateUserRole(id int, role string) error {
+	result, err := DB.Exec(`UPDATE users SET role = $1 WHERE id = $2`, role, id)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PromoteBootstrapAdmin makes the user an admin, but only while no admin exists yet.
+// It reports whether the user was promoted.
+func PromoteBootstrapAdmin(id int) (bool, error) {
+	result, err := DB.Exec(`
+		UPDATE users SET role = $1
+		WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM users WHERE role = $1)`, RoleAdmin, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to promote bootstrap admin: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected == 1, nil
+}
@@ -1,6 +1,6 @@
 module github.com/anurag/magicgate/MyServer
 
-go 1.21
+go 1.24
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.2.1
@@ -10,4 +10,6 @@ require (
 	golang.org/x/crypto v0.21.0
 )
 
+require golang.org/x/sys v0.18.0 // indirect
+
 replace github.com/anurag/magicgate/MyServer => ./
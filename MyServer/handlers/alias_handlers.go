@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/gorilla/mux"
+)
+
+// AliasPutRequest defines the request body for creating or retargeting an alias
+type AliasPutRequest struct {
+	KeyID int `json:"key_id"`
+}
+
+// aliasNameFromPath builds the full alias name ("alias/<name>") from the {name} path variable
+func aliasNameFromPath(r *http.Request) string {
+	return database.AliasPrefix + mux.Vars(r)["name"]
+}
+
+// PutAlias handles creating an alias or atomically pointing an existing alias at another key
+func PutAlias(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	var req AliasPutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.KeyID == 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key ID is required")
+		return
+	}
+
+	alias := &database.KeyAlias{
+		UserID: claims.UserID,
+		Name:   aliasNameFromPath(r),
+		KeyID:  req.KeyID,
+	}
+
+	if err := database.UpsertAlias(alias); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to save alias")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, alias)
+}
+
+// GetAlias handles retrieving a specific alias for the authenticated user
+func GetAlias(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	alias, err := database.GetAliasByName(aliasNameFromPath(r), claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if alias == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Alias not found")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, alias)
+}
+
+// GetAllAliases handles retrieving all aliases for the authenticated user
+func GetAllAliases(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	aliases, err := database.GetAllAliasesForUser(claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, aliases)
+}
+
+// DeleteAlias handles deleting an alias for the authenticated user. The target key is not affected.
+func DeleteAlias(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	err := database.DeleteAlias(aliasNameFromPath(r), claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "Alias not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete alias")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
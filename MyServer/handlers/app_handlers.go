@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/gorilla/mux"
+)
+
+// SDKAPIVersion is the API version reported to SDK clients in their configuration
+const SDKAPIVersion = "1.0"
+
+// AppCreateRequest defines the request body for registering an app
+type AppCreateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AppRegistrationTokenCreateRequest defines the request body for creating a registration token
+type AppRegistrationTokenCreateRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Omit for a token that never expires
+}
+
+// AppRegistrationTokenCreateResponse defines the response body for a newly created registration token
+type AppRegistrationTokenCreateResponse struct {
+	database.AppRegistrationToken
+	// Token is the full registration token. It is shown only in this response; store it securely.
+	Token string `json:"token"`
+}
+
+// SDKConfigResponse defines the configuration served to SDK clients at Init
+type SDKConfigResponse struct {
+	APIVersion string       `json:"api_version"`
+	App        SDKConfigApp `json:"app"`
+	// Features lists the key algorithms the server supports, which is what the SDKs check before using one
+	Features []string        `json:"features"`
+	Policy   SDKClientPolicy `json:"policy"`
+}
+
+// SDKConfigApp identifies the app a registration token belongs to
+type SDKConfigApp struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// SDKClientPolicy is the behaviour the server requires of SDK clients. Durations are in seconds.
+type SDKClientPolicy struct {
+	ConfigTTL   int `json:"config_ttl"`    // How long the configuration may be cached before fetching it again
+	KeyCacheTTL int `json:"key_cache_ttl"` // How long fetched key material may be kept in memory
+}
+
+// getAppFromPath loads the app named by the {id} path variable.
+// On failure it writes the error response and returns false.
+func getAppFromPath(w http.ResponseWriter, r *http.Request) (*database.App, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid app ID")
+		return nil, false
+	}
+
+	app, err := database.GetApp(id)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if app == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "App not found")
+		return nil, false
+	}
+	return app, true
+}
+
+// CreateApp handles registering an app whose SDK clients will fetch their configuration with a registration token
+func CreateApp(w http.ResponseWriter, r *http.Request) {
+	var req AppCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.Name == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "App name is required")
+		return
+	}
+
+	existing, err := database.GetAppByName(req.Name)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if existing != nil {
+		middleware.RespondWithError(w, http.StatusConflict, "App with this name already exists")
+		return
+	}
+
+	app := &database.App{Name: req.Name, Description: req.Description}
+	if err := database.CreateApp(app); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create app")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusCreated, app)
+}
+
+// GetApps handles listing the registered apps
+func GetApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := database.GetApps()
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, apps)
+}
+
+// DeleteApp handles deleting an app. Its registration tokens stop working immediately.
+func DeleteApp(w http.ResponseWriter, r *http.Request) {
+	app, ok := getAppFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteApp(app.ID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "App not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete app")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// CreateAppRegistrationToken handles issuing a new registration token for an app
+func CreateAppRegistrationToken(w http.ResponseWriter, r *http.Request) {
+	app, ok := getAppFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var req AppRegistrationTokenCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	value, prefix, secretHash, err := utils.GenerateRegistrationToken()
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate registration token")
+		return
+	}
+
+	token := database.AppRegistrationToken{
+		AppID:      app.ID,
+		Prefix:     prefix,
+		SecretHash: secretHash,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := database.CreateAppRegistrationToken(&token); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create registration token")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusCreated, AppRegistrationTokenCreateResponse{AppRegistrationToken: token, Token: value})
+}
+
+// GetAppRegistrationTokens handles listing an app's registration tokens, without the tokens themselves
+func GetAppRegistrationTokens(w http.ResponseWriter, r *http.Request) {
+	app, ok := getAppFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := database.GetAppRegistrationTokens(app.ID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, tokens)
+}
+
+// DeleteAppRegistrationToken handles revoking one of an app's registration tokens
+func DeleteAppRegistrationToken(w http.ResponseWriter, r *http.Request) {
+	app, ok := getAppFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["tokenId"])
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid registration token ID")
+		return
+	}
+
+	if err := database.DeleteAppRegistrationToken(tokenID, app.ID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "Registration token not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete registration token")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// authenticateRegistrationToken checks the registration token in the request's
+// "Authorization: Bearer <token>" header and returns its app. On failure it writes the error
+// response and returns false.
+func authenticateRegistrationToken(w http.ResponseWriter, r *http.Request) (*database.App, bool) {
+	authHeader := r.Header.Get("Authorization")
+	value, found := strings.CutPrefix(authHeader, "Bearer ")
+	if !found || value == "" {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Registration token required")
+		return nil, false
+	}
+
+	prefix, secretHash, ok := utils.ParseRegistrationToken(value)
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid registration token")
+		return nil, false
+	}
+
+	token, err := database.GetAppRegistrationTokenByPrefix(prefix)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if token == nil || subtle.ConstantTimeCompare(token.SecretHash, secretHash) != 1 {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid registration token")
+		return nil, false
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(time.Now()) {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Registration token has expired")
+		return nil, false
+	}
+
+	app, err := database.GetApp(token.AppID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if app == nil {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid registration token")
+		return nil, false
+	}
+
+	if err := database.TouchAppRegistrationToken(token.ID); err != nil {
+		log.Printf("Failed to record use of registration token %s: %v", token.Prefix, err)
+	}
+	return app, true
+}
+
+// GetSDKConfig handles serving an SDK client its configuration, authenticated by the app's
+// registration token. The SDKs call it from Init.
+func GetSDKConfig(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, ok := authenticateRegistrationToken(w, r)
+		if !ok {
+			return
+		}
+
+		resp := SDKConfigResponse{
+			APIVersion: SDKAPIVersion,
+			App:        SDKConfigApp{ID: app.ID, Name: app.Name},
+			Features:   supportedKeyAlgorithms,
+			Policy: SDKClientPolicy{
+				ConfigTTL:   int(cfg.SDKConfigTTL.Seconds()),
+				KeyCacheTTL: int(cfg.SDKKeyCacheTTL.Seconds()),
+			},
+		}
+
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(resp.Policy.ConfigTTL))
+		middleware.RespondWithJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+)
+
+// Audit log page sizes
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// AuditLogResponse defines the response body for listing audit events
+type AuditLogResponse struct {
+	Events     []database.AuditEvent `json:"events"`
+	NextCursor string                `json:"next_cursor,omitempty"` // Pass as ?cursor= to fetch the next (older) page
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAuditLogs handles listing the audit log across all users, newest first.
+// Supported query parameters: user_id, action, since and until (RFC 3339), limit and cursor.
+func GetAuditLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := database.AuditLogOptions{Action: query.Get("action"), Limit: defaultAuditPageSize}
+
+	if userIDStr := query.Get("user_id"); userIDStr != "" {
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		opts.UserID = &userID
+	}
+
+	var err error
+	if opts.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid since, expected an RFC 3339 timestamp")
+		return
+	}
+	if opts.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid until, expected an RFC 3339 timestamp")
+		return
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid limit, expected 1 to "+strconv.Itoa(maxAuditPageSize))
+			return
+		}
+		opts.Limit = limit
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		beforeID, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || beforeID < 1 {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		opts.BeforeID = beforeID
+	}
+
+	events, hasMore, err := database.ListAuditEvents(opts)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	resp := AuditLogResponse{Events: events}
+	if hasMore {
+		resp.NextCursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
+}
@@ -1,8 +1,14 @@
 package handlers 
 
 import (
+	"database/sql"
 	"encoding/json"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/anurag/magicgate/MyServer/config"
 	"github.com/anurag/magicgate/MyServer/database"
@@ -18,10 +24,89 @@ type LoginRequest struct {
 
 // LoginResponse defines the response body for successful login
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`         // Access token, sent as "Authorization: Bearer <token>"
+	RefreshToken string `json:"refresh_token"` // Exchanged at /refresh for a new token pair; usable once
+	ExpiresIn    int    `json:"expires_in"`    // Access token lifetime, in seconds
+	// PasswordChangeRequired means the password no longer meets the password policy. The token only
+	// works for /api/me/password and /logout until it is changed.
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
 }
 
-// Login handles user authentication and JWT generation
+// dummyPasswordHash returns a password hash to compare against when the username doesn't exist
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword("magicgate-dummy-password")
+	if err != nil {
+		log.Printf("Error generating dummy password hash: %v", err)
+	}
+	return hash
+})
+
+// usernameThrottleKey returns the key failed logins for a username are counted under
+func usernameThrottleKey(username string) string {
+	return "username:" + username
+}
+
+// ipThrottleKey returns the key failed logins from a client address are counted under
+func ipThrottleKey(addr string) string {
+	return "ip:" + addr
+}
+
+// loginAttempt is an attempt to authenticate as a user, counted against their username and the client
+// address before the credentials are checked
+type loginAttempt struct {
+	throttles []*database.LoginThrottle
+}
+
+// beginLoginAttempt counts an attempt to authenticate as username from the request's client address.
+// Past LoginMaxFailuresPerUsername or LoginMaxFailuresPerIP attempts, each one refuses further attempts
+// for LoginBackoffBase, doubling every time, up to LoginLockoutDuration. If attempts are refused, it
+// writes a 429 response with Retry-After and returns nil.
+func beginLoginAttempt(cfg *config.Config, w http.ResponseWriter, r *http.Request, username string) *loginAttempt {
+	limits := []struct {
+		key         string
+		maxAttempts int
+	}{
+		{usernameThrottleKey(username), cfg.LoginMaxFailuresPerUsername},
+		{ipThrottleKey(middleware.ClientIP(r)), cfg.LoginMaxFailuresPerIP},
+	}
+
+	attempt := &loginAttempt{}
+	for _, limit := range limits {
+		throttle, reserved, err := database.ReserveLoginAttempt(limit.key, time.Now().Add(-cfg.LoginFailureWindow),
+			limit.maxAttempts, cfg.LoginBackoffBase, cfg.LoginLockoutDuration)
+		if err != nil {
+			attempt.succeeded()
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return nil
+		}
+		if !reserved {
+			attempt.succeeded() // Not made after all
+			retryAfter := time.Until(*throttle.LockedUntil)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			middleware.RespondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+			return nil
+		}
+		if throttle.Failures == limit.maxAttempts+1 {
+			log.Printf("Delaying logins for %s after %d attempts", limit.key, limit.maxAttempts)
+		}
+		attempt.throttles = append(attempt.throttles, throttle)
+	}
+	return attempt
+}
+
+// succeeded takes the attempt back, since its credentials were right. Errors are logged, since the
+// credentials have been checked anyway.
+func (a *loginAttempt) succeeded() {
+	for _, throttle := range a.throttles {
+		if err := database.RefundLoginAttempt(throttle.Key, throttle.Failures); err != nil {
+			log.Printf("Failed to refund login attempt for %s: %v", throttle.Key, err)
+		}
+	}
+}
+
+// Login handles user authentication, starting a new session with an access token and a refresh token.
+// Users with MFA enabled get an MFA challenge instead, completed at /login/mfa. Repeated failures for
+// a username or from a client address delay further attempts, up to a temporary lockout.
 func Login(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req LoginRequest
@@ -35,27 +120,104 @@ func Login(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
+		attempt := beginLoginAttempt(cfg, w, r, req.Username)
+		if attempt == nil {
+			return
+		}
+
 		user, err := database.GetUserByUsername(req.Username)
 		if err != nil {
 			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
 		if user == nil {
+			// Compare anyway, so the response takes as long as for an existing user
+			utils.CheckPasswordHash(req.Password, dummyPasswordHash())
 			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials")
 			return
 		}
 
-		if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
+		ok, needsRehash := utils.VerifyPassword(req.Password, user.PasswordHash)
+		if !ok {
 			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials")
 			return
 		}
+		attempt.succeeded()
+		if needsRehash {
+			rehashPassword(user, req.Password)
+		}
+
+		// With PASSWORD_CHECK_AT_LOGIN, users whose password has fallen foul of the policy, e.g. by
+		// turning up in a breach, must change it before doing anything else
+		if cfg.PasswordCheckAtLogin && !user.PasswordChangeRequired && !passwordMeetsPolicy(cfg, req.Password, user.Username) {
+			if err := database.RequireUserPasswordChange(user.ID); err != nil {
+				middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			user.PasswordChangeRequired = true
+		}
+
+		// Users with MFA enabled get a challenge to complete at /login/mfa instead of a session
+		totp, err := database.GetUserTOTP(user.ID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if totp != nil && totp.ConfirmedAt != nil {
+			startMFAChallenge(cfg, w, user)
+			return
+		}
+
+		completeLogin(cfg, w, r, user)
+	}
+}
+
+// rehashPassword replaces a password hash made with an outdated algorithm, parameters or pepper by one
+// made with the current ones, now that the password is at hand. Failures are only logged: the old hash
+// still works, and the next login tries again.
+func rehashPassword(user *database.User, password string) {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of %q: %v", user.Username, err)
+		return
+	}
+	if err := database.RehashUserPassword(user.ID, user.PasswordHash, hash); err != nil {
+		if err != sql.ErrNoRows { // Changed meanwhile, nothing to upgrade
+			log.Printf("Failed to rehash password of %q: %v", user.Username, err)
+		}
+		return
+	}
+	user.PasswordHash = hash
+}
 
-		token, err := utils.GenerateJWT(user.ID, user.Username, []byte(cfg.JWTSecret))
+// completeLogin starts a session for user, whose credentials have been fully checked, and writes
+// the login response
+func completeLogin(cfg *config.Config, w http.ResponseWriter, r *http.Request, user *database.User) {
+	// Only now, past any MFA step, is the username's count reset. The address keeps its count, so one
+	// valid account can't be used to reset it while guessing others.
+	if err := database.ClearLoginFailures(usernameThrottleKey(user.Username)); err != nil {
+		log.Printf("Failed to clear login failures of %q: %v", user.Username, err)
+	}
+
+	// The first admin is whoever logs in as BOOTSTRAP_ADMIN_USERNAME while there is no admin yet. Only the
+	// holder of BOOTSTRAP_ADMIN_TOKEN can register that name, and no user can be renamed to it.
+	if cfg.BootstrapAdminUsername != "" && user.Username == cfg.BootstrapAdminUsername && user.Role != database.RoleAdmin {
+		promoted, err := database.PromoteBootstrapAdmin(user.ID)
 		if err != nil {
-			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
+		if promoted {
+			log.Printf("Promoted bootstrap admin %q", user.Username)
+			user.Role = database.RoleAdmin
+		}
+	}
 
-		middleware.RespondWithJSON(w, http.StatusOK, LoginResponse{Token: token})
+	resp, err := startSession(cfg, r, user)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to start session")
+		return
 	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
 }
\ No newline at end of file
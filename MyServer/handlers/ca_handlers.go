@@ -0,0 +1,819 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/gorilla/mux"
+)
+
+// minCSRRSABits is the smallest RSA key the CA will certify
+const minCSRRSABits = 2048
+
+// CACreateRequest defines the request body for turning a key into a CA
+type CACreateRequest struct {
+	CommonName         string   `json:"common_name"`
+	Organization       []string `json:"organization"`
+	OrganizationalUnit []string `json:"organizational_unit"`
+	Country            []string `json:"country"`
+	ValidityDays       int      `json:"validity_days"`
+	// ParentID makes the CA an intermediate issued by the CA backed by this key; omit for a root CA
+	ParentID *int `json:"parent_id,omitempty"`
+	// MaxPathLen limits how many intermediates may follow this CA; omit for no limit, 0 for none
+	MaxPathLen *int `json:"max_path_len,omitempty"`
+}
+
+// CAResponse defines the response body describing a CA
+type CAResponse struct {
+	KeyID       int       `json:"key_id"`
+	ParentKeyID *int      `json:"parent_key_id,omitempty"`
+	KeyVersion  int       `json:"key_version"`
+	Subject     string    `json:"subject"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Certificate string    `json:"certificate"` // PEM
+	// Chain holds the PEM certificates of the issuing CAs, from the parent up to the root
+	Chain     []string  `json:"chain,omitempty"`
+	CRLNumber int64     `json:"crl_number"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CertificateTemplateRequest defines the request body for creating or replacing a certificate template
+type CertificateTemplateRequest struct {
+	AllowedDomains     []string `json:"allowed_domains"`
+	AllowSubdomains    bool     `json:"allow_subdomains"`
+	AllowedIPRanges    []string `json:"allowed_ip_ranges"`    // CIDRs
+	AllowedURIPrefixes []string `json:"allowed_uri_prefixes"` // e.g. spiffe://mesh.internal/ns/prod
+	KeyUsages          []string `json:"key_usages"`           // Defaults to digital_signature and key_encipherment
+	ExtKeyUsages       []string `json:"ext_key_usages"`       // Defaults to server_auth and client_auth
+	TTL                int      `json:"ttl"`                  // In seconds; required
+	MaxTTL             int      `json:"max_ttl"`              // In seconds; defaults to ttl
+}
+
+// SignCSRRequest defines the request body for issuing a certificate from a CSR
+type SignCSRRequest struct {
+	CSR      string `json:"csr"` // PEM
+	Template string `json:"template"`
+	TTL      int    `json:"ttl,omitempty"` // In seconds; defaults to the template's ttl
+}
+
+// SignCSRResponse defines the response body for an issued certificate
+type SignCSRResponse struct {
+	SerialNumber string    `json:"serial_number"`
+	Certificate  string    `json:"certificate"` // PEM
+	Chain        []string  `json:"chain"`       // PEM, from the issuing CA up to the root
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// RevokeCertificateRequest defines the request body for revoking a certificate
+type RevokeCertificateRequest struct {
+	Reason string `json:"reason"` // An RFC 5280 reason such as "key_compromise"; defaults to "unspecified"
+}
+
+// getCAFromPath loads the authenticated user's CA backed by the key identified by the {id} path variable.
+// On failure it writes the error response and returns false.
+func getCAFromPath(w http.ResponseWriter, r *http.Request) (*database.CertificateAuthority, *database.Key, bool) {
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	ca, err := database.GetCertificateAuthorityForUser(key.ID, key.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, nil, false
+	}
+	if ca == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Key is not a certificate authority")
+		return nil, nil, false
+	}
+	return ca, key, true
+}
+
+// caSigner returns a CA's certificate and the private key of the key version it certifies.
+// On failure it writes the error response and returns false.
+func caSigner(w http.ResponseWriter, ca *database.CertificateAuthority, key *database.Key) (*x509.Certificate, crypto.Signer, bool) {
+	material, ok := keyMaterialForVersion(w, key, ca.KeyVersion)
+	if !ok {
+		return nil, nil, false
+	}
+	signer, err := utils.ParsePrivateKey(material)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load CA key")
+		return nil, nil, false
+	}
+	cert, err := x509.ParseCertificate(ca.Certificate)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load CA certificate")
+		return nil, nil, false
+	}
+	return cert, signer, true
+}
+
+// caChain returns the PEM certificates of ca's issuers, from its parent up to the root
+func caChain(ca *database.CertificateAuthority) ([]string, error) {
+	chain := []string{}
+	for parentID := ca.ParentKeyID; parentID != nil; {
+		parent, err := database.GetCertificateAuthority(*parentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			break
+		}
+		chain = append(chain, utils.EncodeCertificatePEM(parent.Certificate))
+		parentID = parent.ParentKeyID
+	}
+	return chain, nil
+}
+
+// toCAResponse describes a CA, including its issuer chain.
+// On failure it writes the error response and returns false.
+func toCAResponse(w http.ResponseWriter, ca *database.CertificateAuthority) (CAResponse, bool) {
+	cert, err := x509.ParseCertificate(ca.Certificate)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load CA certificate")
+		return CAResponse{}, false
+	}
+	chain, err := caChain(ca)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return CAResponse{}, false
+	}
+	return CAResponse{
+		KeyID:       ca.KeyID,
+		ParentKeyID: ca.ParentKeyID,
+		KeyVersion:  ca.KeyVersion,
+		Subject:     cert.Subject.String(),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Certificate: utils.EncodeCertificatePEM(ca.Certificate),
+		Chain:       chain,
+		CRLNumber:   ca.CRLNumber,
+		CreatedAt:   ca.CreatedAt,
+	}, true
+}
+
+// CreateCA handles turning one of the authenticated user's RSA or EC keys into a root CA, or an
+// intermediate CA issued by another of the user's CAs. The CA stays bound to the key's current
+// version, so later rotations of the key don't change the CA.
+func CreateCA(w http.ResponseWriter, r *http.Request) {
+	var req CACreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.CommonName == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Common name is required")
+		return
+	}
+	if req.ValidityDays <= 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "validity_days must be positive")
+		return
+	}
+	if req.MaxPathLen != nil && *req.MaxPathLen < 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "max_path_len cannot be negative")
+		return
+	}
+
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireKeyEnabled(w, key) || !requireKeyAlgorithm(w, key, utils.AlgorithmRSA2048, utils.AlgorithmRSA3072, utils.AlgorithmRSA4096,
+		utils.AlgorithmECP256, utils.AlgorithmECP384, utils.AlgorithmECP521) {
+		return
+	}
+
+	now := time.Now()
+	if !requireKeyCanEncrypt(w, key, now) {
+		return
+	}
+
+	existing, err := database.GetCertificateAuthorityForUser(key.ID, key.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if existing != nil {
+		middleware.RespondWithError(w, http.StatusConflict, "Key is already a certificate authority")
+		return
+	}
+
+	caKey, err := utils.ParsePrivateKey(key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load key")
+		return
+	}
+
+	notAfter := now.AddDate(0, 0, req.ValidityDays)
+	maxPathLen := -1
+	if req.MaxPathLen != nil {
+		maxPathLen = *req.MaxPathLen
+	}
+
+	var issuer *x509.Certificate
+	var issuerKey crypto.Signer
+	if req.ParentID != nil {
+		parent, err := database.GetCertificateAuthorityForUser(*req.ParentID, key.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if parent == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "Parent CA not found or not owned by user")
+			return
+		}
+		parentKey, err := database.GetKeyByID(parent.KeyID, key.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !requireKeyEnabled(w, parentKey) || !requireKeyCanEncrypt(w, parentKey, now) {
+			return
+		}
+		if issuer, issuerKey, ok = caSigner(w, parent, parentKey); !ok {
+			return
+		}
+		if issuer.MaxPathLenZero || issuer.MaxPathLen > 0 && maxPathLen >= issuer.MaxPathLen {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Parent CA's path length constraint does not allow this intermediate")
+			return
+		}
+		if issuer.MaxPathLen > 0 && maxPathLen < 0 {
+			maxPathLen = issuer.MaxPathLen - 1
+		}
+		if notAfter.After(issuer.NotAfter) {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Intermediate CA cannot outlive its parent, which expires "+issuer.NotAfter.Format(time.RFC3339))
+			return
+		}
+	}
+
+	subject := pkix.Name{
+		CommonName:         req.CommonName,
+		Organization:       req.Organization,
+		OrganizationalUnit: req.OrganizationalUnit,
+		Country:            req.Country,
+	}
+	der, err := utils.CreateCACertificate(subject, caKey, notAfter, maxPathLen, issuer, issuerKey)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create CA certificate")
+		return
+	}
+
+	ca := &database.CertificateAuthority{
+		KeyID:       key.ID,
+		UserID:      key.UserID,
+		KeyVersion:  key.Version,
+		ParentKeyID: req.ParentID,
+		Certificate: der,
+	}
+	if err := database.CreateCertificateAuthority(ca); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create certificate authority")
+		return
+	}
+
+	resp, ok := toCAResponse(w, ca)
+	if !ok {
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusCreated, resp)
+}
+
+// GetCA handles retrieving one of the authenticated user's CAs, with its certificate and chain
+func GetCA(w http.ResponseWriter, r *http.Request) {
+	ca, _, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+	resp, ok := toCAResponse(w, ca)
+	if !ok {
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// PutCertificateTemplate handles creating or replacing a CA's certificate template named by the {name} path variable
+func PutCertificateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req CertificateTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.TTL <= 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "ttl must be positive")
+		return
+	}
+	if req.MaxTTL == 0 {
+		req.MaxTTL = req.TTL
+	}
+	if req.MaxTTL < req.TTL {
+		middleware.RespondWithError(w, http.StatusBadRequest, "max_ttl cannot be less than ttl")
+		return
+	}
+	if len(req.KeyUsages) == 0 {
+		req.KeyUsages = []string{"digital_signature", "key_encipherment"}
+	}
+	if len(req.ExtKeyUsages) == 0 {
+		req.ExtKeyUsages = []string{"server_auth", "client_auth"}
+	}
+	if _, err := utils.ParseKeyUsages(req.KeyUsages); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := utils.ParseExtKeyUsages(req.ExtKeyUsages); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for i, domain := range req.AllowedDomains {
+		req.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(domain))
+		if req.AllowedDomains[i] == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Allowed domains cannot be empty")
+			return
+		}
+	}
+	for i, cidr := range req.AllowedIPRanges {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid allowed IP range "+cidr)
+			return
+		}
+		req.AllowedIPRanges[i] = ipNet.String()
+	}
+	for i, prefix := range req.AllowedURIPrefixes {
+		parsed, err := parseURIPrefix(strings.TrimSpace(prefix))
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid allowed URI prefix "+prefix+": "+err.Error())
+			return
+		}
+		req.AllowedURIPrefixes[i] = parsed.String()
+	}
+
+	ca, _, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	template := &database.CertificateTemplate{
+		CAKeyID:            ca.KeyID,
+		Name:               mux.Vars(r)["name"],
+		AllowedDomains:     req.AllowedDomains,
+		AllowSubdomains:    req.AllowSubdomains,
+		AllowedIPRanges:    req.AllowedIPRanges,
+		AllowedURIPrefixes: req.AllowedURIPrefixes,
+		KeyUsages:          req.KeyUsages,
+		ExtKeyUsages:       req.ExtKeyUsages,
+		TTL:                req.TTL,
+		MaxTTL:             req.MaxTTL,
+	}
+	if err := database.UpsertCertificateTemplate(template); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to save certificate template")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, template)
+}
+
+// GetCertificateTemplates handles listing a CA's certificate templates
+func GetCertificateTemplates(w http.ResponseWriter, r *http.Request) {
+	ca, _, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+	templates, err := database.GetCertificateTemplates(ca.KeyID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve certificate templates")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, templates)
+}
+
+// DeleteCertificateTemplate handles deleting a CA's certificate template
+func DeleteCertificateTemplate(w http.ResponseWriter, r *http.Request) {
+	ca, _, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := database.DeleteCertificateTemplate(ca.KeyID, mux.Vars(r)["name"]); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "Certificate template not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete certificate template")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Certificate template deleted successfully"})
+}
+
+// domainAllowed reports whether a DNS name matches one of a template's allowed domains.
+// With allowSubdomains, names below an allowed domain (including wildcards such as *.svc.example.com) match too.
+func domainAllowed(name string, allowedDomains []string, allowSubdomains bool) bool {
+	for _, domain := range allowedDomains {
+		if name == domain || allowSubdomains && strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseURIPrefix parses an allowed URI SAN prefix: an absolute URI with a host, and a path of whole
+// segments, without userinfo, query or fragment
+func parseURIPrefix(prefix string) (*url.URL, error) {
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("must have a scheme and a host")
+	}
+	if !plainURI(u) {
+		return nil, fmt.Errorf("must not have userinfo, a query, a fragment, escaped characters or dot segments")
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u, nil
+}
+
+// plainURI reports whether u has none of the parts that could make the same URI look different to
+// the CA and to a relying party: userinfo, a query, a fragment, escaped path characters or dot segments
+func plainURI(u *url.URL) bool {
+	if u.Opaque != "" || u.User != nil || u.RawQuery != "" || u.ForceQuery || u.Fragment != "" || u.RawFragment != "" || u.RawPath != "" {
+		return false
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// uriAllowed reports whether a URI SAN matches one of the allowed prefixes: the same scheme and host,
+// and a path equal to or below the prefix's path, compared on whole segments
+func uriAllowed(uri *url.URL, prefixes []*url.URL) bool {
+	if !plainURI(uri) {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if uri.Scheme != prefix.Scheme || !strings.EqualFold(uri.Host, prefix.Host) {
+			continue
+		}
+		base := strings.TrimSuffix(prefix.Path, "/")
+		if base == "" || uri.Path == base || strings.HasPrefix(uri.Path, base+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed reports whether ip is within one of the allowed CIDRs
+func ipAllowed(ip net.IP, allowedRanges []*net.IPNet) bool {
+	for _, ipNet := range allowedRanges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrDNSNames checks a CSR's names against a template and returns the DNS names to certify: the CSR's
+// DNS SANs plus its common name. It returns a message describing the first name the template doesn't allow.
+func csrDNSNames(csr *x509.CertificateRequest, template *database.CertificateTemplate) ([]string, string) {
+	dnsNames := []string{}
+	seen := map[string]bool{}
+	names := csr.DNSNames
+	if csr.Subject.CommonName != "" {
+		names = append([]string{csr.Subject.CommonName}, names...)
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if !domainAllowed(name, template.AllowedDomains, template.AllowSubdomains) {
+			return nil, "Name " + name + " is not allowed by template " + template.Name
+		}
+		dnsNames = append(dnsNames, name)
+	}
+
+	// Ranges and prefixes are validated when the template is saved; any that don't parse allow nothing
+	var ipRanges []*net.IPNet
+	for _, cidr := range template.AllowedIPRanges {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			ipRanges = append(ipRanges, ipNet)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !ipAllowed(ip, ipRanges) {
+			return nil, "IP SAN " + ip.String() + " is not allowed by template " + template.Name
+		}
+	}
+
+	var uriPrefixes []*url.URL
+	for _, prefix := range template.AllowedURIPrefixes {
+		if parsed, err := parseURIPrefix(prefix); err == nil {
+			uriPrefixes = append(uriPrefixes, parsed)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !uriAllowed(uri, uriPrefixes) {
+			return nil, "URI SAN " + uri.String() + " is not allowed by template " + template.Name
+		}
+	}
+	if len(csr.EmailAddresses) > 0 {
+		return nil, "Email SANs are not supported"
+	}
+	if len(dnsNames) == 0 && len(csr.IPAddresses) == 0 && len(csr.URIs) == 0 {
+		return nil, "CSR must contain a common name or at least one SAN"
+	}
+	return dnsNames, ""
+}
+
+// SignCSR handles issuing a certificate from a PEM CSR with one of the authenticated user's CAs.
+// The names in the CSR must be allowed by the given template, which also sets the key usages and lifetime.
+func SignCSR(w http.ResponseWriter, r *http.Request) {
+	var req SignCSRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.CSR == "" || req.Template == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "CSR and template are required")
+		return
+	}
+	if req.TTL < 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "ttl cannot be negative")
+		return
+	}
+
+	csr, err := utils.ParseCSRPEM(req.CSR)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid CSR: "+err.Error())
+		return
+	}
+	if rsaKey, ok := csr.PublicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minCSRRSABits {
+		middleware.RespondWithError(w, http.StatusBadRequest, "RSA keys must be at least "+strconv.Itoa(minCSRRSABits)+" bits")
+		return
+	}
+
+	ca, key, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if !requireKeyEnabled(w, key) || !requireKeyCanEncrypt(w, key, now) {
+		return
+	}
+
+	template, err := database.GetCertificateTemplate(ca.KeyID, req.Template)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if template == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Certificate template not found")
+		return
+	}
+
+	ttl := template.TTL
+	if req.TTL != 0 {
+		ttl = req.TTL
+	}
+	if ttl > template.MaxTTL {
+		middleware.RespondWithError(w, http.StatusBadRequest, "ttl exceeds the template's max_ttl of "+strconv.Itoa(template.MaxTTL)+" seconds")
+		return
+	}
+
+	dnsNames, msg := csrDNSNames(csr, template)
+	if msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	keyUsage, err := utils.ParseKeyUsages(template.KeyUsages)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Invalid certificate template: "+err.Error())
+		return
+	}
+	extKeyUsages, err := utils.ParseExtKeyUsages(template.ExtKeyUsages)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Invalid certificate template: "+err.Error())
+		return
+	}
+
+	issuer, issuerKey, ok := caSigner(w, ca, key)
+	if !ok {
+		return
+	}
+	notAfter := now.Add(time.Duration(ttl) * time.Second)
+	if notAfter.After(issuer.NotAfter) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Certificate cannot outlive its CA, which expires "+issuer.NotAfter.Format(time.RFC3339))
+		return
+	}
+
+	der, serial, err := utils.IssueCertificate(csr, dnsNames, keyUsage, extKeyUsages, notAfter, issuer, issuerKey)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to issue certificate")
+		return
+	}
+	issued, err := x509.ParseCertificate(der)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to issue certificate")
+		return
+	}
+
+	cert := &database.Certificate{
+		SerialNumber: serial.Text(16),
+		CAKeyID:      ca.KeyID,
+		TemplateName: template.Name,
+		CommonName:   csr.Subject.CommonName,
+		DNSNames:     dnsNames,
+		Certificate:  der,
+		NotBefore:    issued.NotBefore,
+		NotAfter:     issued.NotAfter,
+	}
+	if err := database.CreateCertificate(cert); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to record certificate")
+		return
+	}
+
+	chain, err := caChain(ca)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, SignCSRResponse{
+		SerialNumber: cert.SerialNumber,
+		Certificate:  utils.EncodeCertificatePEM(der),
+		Chain:        append([]string{utils.EncodeCertificatePEM(ca.Certificate)}, chain...),
+		NotAfter:     cert.NotAfter,
+	})
+}
+
+// GetCertificates handles listing the certificates issued by one of the authenticated user's CAs
+func GetCertificates(w http.ResponseWriter, r *http.Request) {
+	ca, _, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+	certificates, err := database.GetCertificatesForCA(ca.KeyID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve certificates")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, certificates)
+}
+
+// RevokeCertificate handles revoking a certificate issued by one of the authenticated user's CAs.
+// The certificate is listed in the CA's CRL until it expires.
+func RevokeCertificate(w http.ResponseWriter, r *http.Request) {
+	var req RevokeCertificateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "unspecified"
+	}
+	reason, known := utils.CRLReasons[req.Reason]
+	if !known {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Unknown revocation reason: "+req.Reason)
+		return
+	}
+
+	ca, _, ok := getCAFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	cert, err := database.GetCertificate(ca.KeyID, strings.ToLower(mux.Vars(r)["serial"]))
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if cert == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Certificate not found")
+		return
+	}
+	if err := database.RevokeCertificate(cert, reason); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusConflict, "Certificate is already revoked")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke certificate")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, cert)
+}
+
+// GetCRL handles serving a CA's DER-encoded certificate revocation list. It is public so relying
+// parties can fetch it, and is served even while the CA key is disabled, since revocations must
+// stay visible. The signed CRL is cached, and a new one with an increasing CRL number is signed only
+// after a revocation or once less than half of CRL_VALIDITY remains until its next update.
+func GetCRL(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid CA ID")
+			return
+		}
+
+		ca, err := database.GetCertificateAuthority(id)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if ca == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "Certificate authority not found")
+			return
+		}
+
+		crl, nextUpdate, err := database.GetCachedCRL(ca.KeyID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if crl == nil || nextUpdate == nil || time.Until(*nextUpdate) < cfg.CRLValidity/2 {
+			var ok bool
+			if crl, ok = signCRL(cfg, w, ca); !ok {
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.WriteHeader(http.StatusOK)
+		w.Write(crl)
+	}
+}
+
+// signCRL signs and caches a new CRL for ca, listing its revoked certificates that haven't expired.
+// If it fails, it writes an error response and returns false.
+func signCRL(cfg *config.Config, w http.ResponseWriter, ca *database.CertificateAuthority) ([]byte, bool) {
+	key, err := database.GetKeyByID(ca.KeyID, ca.UserID)
+	if err != nil || key == nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load CA key")
+		return nil, false
+	}
+	issuer, issuerKey, ok := caSigner(w, ca, key)
+	if !ok {
+		return nil, false
+	}
+
+	// The number is taken before the revocations are read, so a revocation in between, which bumps it,
+	// stops this CRL from being cached
+	number, err := database.NextCRLNumber(ca.KeyID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+
+	now := time.Now()
+	revoked, err := database.GetRevokedCertificates(ca.KeyID, now)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, cert := range revoked {
+		parsed, err := x509.ParseCertificate(cert.Certificate)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load revoked certificate")
+			return nil, false
+		}
+		entry := x509.RevocationListEntry{SerialNumber: parsed.SerialNumber, RevocationTime: *cert.RevokedAt}
+		if cert.RevocationReason != nil {
+			entry.ReasonCode = *cert.RevocationReason
+		}
+		entries = append(entries, entry)
+	}
+
+	nextUpdate := now.Add(cfg.CRLValidity)
+	crl, err := utils.CreateCRL(number, entries, nextUpdate, issuer, issuerKey)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create CRL")
+		return nil, false
+	}
+	if err := database.SaveCRL(ca.KeyID, number, crl, nextUpdate); err != nil {
+		log.Printf("Failed to cache CRL of CA %d: %v", ca.KeyID, err) // Still valid, so serve it anyway
+	}
+	return crl, true
+}
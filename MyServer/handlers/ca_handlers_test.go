@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// newTestCSR returns a parsed CSR for a new key, as a client would send it
+func newTestCSR(t *testing.T, request *x509.CertificateRequest) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, request, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestCSRDNSNames(t *testing.T) {
+	template := &database.CertificateTemplate{
+		Name:               "mesh",
+		AllowedDomains:     []string{"svc.example.com"},
+		AllowSubdomains:    true,
+		AllowedIPRanges:    []string{"10.1.0.0/16"},
+		AllowedURIPrefixes: []string{"spiffe://mesh.internal/"},
+	}
+	spiffe, _ := url.Parse("spiffe://mesh.internal/ns/default/sa/api")
+	other, _ := url.Parse("spiffe://other.internal/ns/default/sa/api")
+
+	tests := []struct {
+		name      string
+		request   x509.CertificateRequest
+		wantNames []string
+		wantError string
+	}{
+		{
+			name:      "common name and SANs",
+			request:   x509.CertificateRequest{Subject: pkix.Name{CommonName: "API.svc.example.com"}, DNSNames: []string{"api.svc.example.com", "*.api.svc.example.com"}},
+			wantNames: []string{"api.svc.example.com", "*.api.svc.example.com"},
+		},
+		{
+			name:      "allowed domain itself",
+			request:   x509.CertificateRequest{DNSNames: []string{"svc.example.com"}},
+			wantNames: []string{"svc.example.com"},
+		},
+		{
+			name:      "URI SAN only",
+			request:   x509.CertificateRequest{URIs: []*url.URL{spiffe}},
+			wantNames: []string{},
+		},
+		{
+			name:      "common name outside allowed domains",
+			request:   x509.CertificateRequest{Subject: pkix.Name{CommonName: "evil.example.com"}},
+			wantError: "Name evil.example.com is not allowed",
+		},
+		{
+			name:      "SAN outside allowed domains",
+			request:   x509.CertificateRequest{DNSNames: []string{"api.svc.example.com", "example.com"}},
+			wantError: "Name example.com is not allowed",
+		},
+		{
+			name:      "suffix without a dot",
+			request:   x509.CertificateRequest{DNSNames: []string{"evilsvc.example.com"}},
+			wantError: "Name evilsvc.example.com is not allowed",
+		},
+		{
+			name:      "IP SAN in allowed range",
+			request:   x509.CertificateRequest{DNSNames: []string{"api.svc.example.com"}, IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}},
+			wantNames: []string{"api.svc.example.com"},
+		},
+		{
+			name:      "IP SAN outside allowed ranges",
+			request:   x509.CertificateRequest{DNSNames: []string{"api.svc.example.com"}, IPAddresses: []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("10.0.0.1")}},
+			wantError: "IP SAN 10.0.0.1 is not allowed",
+		},
+		{
+			name:      "loopback IP SAN",
+			request:   x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+			wantError: "IP SAN 127.0.0.1 is not allowed",
+		},
+		{
+			name:      "URI SAN outside allowed prefixes",
+			request:   x509.CertificateRequest{URIs: []*url.URL{other}},
+			wantError: "URI SAN spiffe://other.internal/ns/default/sa/api is not allowed",
+		},
+		{
+			name:      "email SAN",
+			request:   x509.CertificateRequest{DNSNames: []string{"api.svc.example.com"}, EmailAddresses: []string{"admin@example.com"}},
+			wantError: "Email SANs are not supported",
+		},
+		{
+			name:      "no names",
+			request:   x509.CertificateRequest{Subject: pkix.Name{Organization: []string{"Admins"}}},
+			wantError: "CSR must contain a common name or at least one SAN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, msg := csrDNSNames(newTestCSR(t, &tt.request), template)
+			if tt.wantError != "" {
+				if !strings.HasPrefix(msg, tt.wantError) {
+					t.Errorf("csrDNSNames() message = %q, want prefix %q", msg, tt.wantError)
+				}
+				return
+			}
+			if msg != "" {
+				t.Fatalf("csrDNSNames() rejected the CSR: %s", msg)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("csrDNSNames() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestCSRDNSNamesWithoutSubdomains(t *testing.T) {
+	template := &database.CertificateTemplate{Name: "exact", AllowedDomains: []string{"svc.example.com"}}
+	csr := newTestCSR(t, &x509.CertificateRequest{DNSNames: []string{"api.svc.example.com"}})
+	if _, msg := csrDNSNames(csr, template); msg == "" {
+		t.Error("csrDNSNames() allowed a subdomain with allow_subdomains off")
+	}
+}
+
+func TestURIAllowed(t *testing.T) {
+	var prefixes []*url.URL
+	for _, prefix := range []string{"spiffe://mesh.internal/ns/prod", "https://Example.com"} {
+		parsed, err := parseURIPrefix(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes = append(prefixes, parsed)
+	}
+
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"spiffe://mesh.internal/ns/prod", true},
+		{"spiffe://mesh.internal/ns/prod/sa/api", true},
+		{"https://example.com/anything", true},
+		{"https://example.com", true},
+		{"spiffe://mesh.internal/ns/production", false},
+		{"spiffe://mesh.internal/ns", false},
+		{"spiffe://mesh.internal.evil/ns/prod", false},
+		{"https://example.com.evil/", false},
+		{"http://example.com/", false},
+		{"spiffe://mesh.internal/ns/prod/../admin", false},
+		{"spiffe://mesh.internal/ns/prod/./sa", false},
+		{"spiffe://mesh.internal/ns/prod/sa%2Fapi", false},
+		{"spiffe://user@mesh.internal/ns/prod", false},
+		{"spiffe://mesh.internal/ns/prod?x=1", false},
+		{"spiffe://mesh.internal/ns/prod#sa", false},
+	}
+	for _, tt := range tests {
+		uri, err := url.Parse(tt.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := uriAllowed(uri, prefixes); got != tt.want {
+			t.Errorf("uriAllowed(%s) = %v, want %v", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestParseURIPrefix(t *testing.T) {
+	for _, prefix := range []string{"mesh.internal/ns", "spiffe:///ns", "spiffe://user@mesh.internal/", "spiffe://mesh.internal/?q", "spiffe://mesh.internal/#f", "spiffe://mesh.internal/ns/../x"} {
+		if _, err := parseURIPrefix(prefix); err == nil {
+			t.Errorf("parseURIPrefix(%q) accepted an invalid prefix", prefix)
+		}
+	}
+}
+
+func TestSignCSRAgainstTemplate(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caDER, err := utils.CreateCACertificate(pkix.Name{CommonName: "Mesh CA"}, caKey, time.Now().Add(24*time.Hour), 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &database.CertificateTemplate{
+		Name:           "mesh",
+		AllowedDomains: []string{"svc.example.com"},
+		KeyUsages:      []string{"digital_signature"},
+		ExtKeyUsages:   []string{"client_auth"},
+	}
+	csr := newTestCSR(t, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "svc.example.com", Organization: []string{"Admins"}, OrganizationalUnit: []string{"ops"}},
+	})
+
+	dnsNames, msg := csrDNSNames(csr, template)
+	if msg != "" {
+		t.Fatalf("csrDNSNames() rejected the CSR: %s", msg)
+	}
+	keyUsage, err := utils.ParseKeyUsages(template.KeyUsages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extKeyUsages, err := utils.ParseExtKeyUsages(template.ExtKeyUsages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _, err := utils.IssueCertificate(csr, dnsNames, keyUsage, extKeyUsages, time.Now().Add(time.Hour), ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != "svc.example.com" || len(cert.Subject.Organization) != 0 || len(cert.Subject.OrganizationalUnit) != 0 {
+		t.Errorf("subject = %s, want only the common name", cert.Subject)
+	}
+	if !reflect.DeepEqual(cert.DNSNames, []string{"svc.example.com"}) {
+		t.Errorf("DNS names = %v, want [svc.example.com]", cert.DNSNames)
+	}
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature || !reflect.DeepEqual(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("key usages = %v, %v; want the template's", cert.KeyUsage, cert.ExtKeyUsage)
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("certificate isn't signed by the CA: %v", err)
+	}
+}
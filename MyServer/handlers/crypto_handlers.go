@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/anurag/magicgate/MyServer/config"
 	"github.com/anurag/magicgate/MyServer/database"
 	"github.com/anurag/magicgate/MyServer/middleware"
 	"github.com/anurag/magicgate/MyServer/utils"
@@ -14,7 +16,7 @@ import (
 
 // EncryptRequest defines the request body for encrypting data
 type EncryptRequest struct {
-	KeyName string `json:"key_name"`
+	KeyName string `json:"key_name"` // A key name or an alias such as "alias/payments"
 	Data    string `json:"data"`
 }
 
@@ -22,13 +24,16 @@ type EncryptRequest struct {
 type EncryptResponse struct {
 	EncryptedData string `json:"encrypted_data"`
 	Nonce         string `json:"nonce"`
+	KeyVersion    int    `json:"key_version"`
 }
 
 // DecryptRequest defines the request body for decrypting data
 type DecryptRequest struct {
-	KeyName string `json:"key_name"`
-	Data    string `json:"data"` // This is the encrypted data
+	KeyName string `json:"key_name"` // A key name or an alias such as "alias/payments"
+	Data    string `json:"data"`     // This is the encrypted data
 	Nonce   string `json:"nonce"`
+	// KeyVersion selects the key version the data was encrypted under; 0 means the current version
+	KeyVersion int `json:"key_version,omitempty"`
 }
 
 // DecryptResponse defines the response body for decrypted data
@@ -36,6 +41,75 @@ type DecryptResponse struct {
 	DecryptedData string `json:"decrypted_data"`
 }
 
+// requireKeyEnabled responds with an error and returns false unless key is enabled.
+// Disabled and pending_deletion keys keep their metadata but refuse crypto operations.
+func requireKeyEnabled(w http.ResponseWriter, key *database.Key) bool {
+	if key.State != database.KeyStateEnabled {
+		middleware.RespondWithError(w, http.StatusConflict, "Key is "+key.State+" and cannot be used")
+		return false
+	}
+	return true
+}
+
+// requireKeyAlgorithm responds with an error and returns false unless key uses one of the given algorithms
+func requireKeyAlgorithm(w http.ResponseWriter, key *database.Key, algorithms ...string) bool {
+	for _, algorithm := range algorithms {
+		if key.Algorithm == algorithm {
+			return true
+		}
+	}
+	middleware.RespondWithError(w, http.StatusBadRequest, "Key algorithm "+key.Algorithm+" does not support this operation")
+	return false
+}
+
+// requireKeyCanEncrypt responds with an error and returns false if now is outside the key's
+// not_before/not_after window
+func requireKeyCanEncrypt(w http.ResponseWriter, key *database.Key, now time.Time) bool {
+	if key.NotBefore != nil && now.Before(*key.NotBefore) {
+		middleware.RespondWithError(w, http.StatusConflict, "Key is not yet valid for encryption")
+		return false
+	}
+	if key.NotAfter != nil && now.After(*key.NotAfter) {
+		middleware.RespondWithError(w, http.StatusConflict, "Key has expired for encryption")
+		return false
+	}
+	return true
+}
+
+// requireKeyCanDecrypt responds with an error and returns false if now is before the key's not_before,
+// or more than graceDays past its not_after. The grace period lets data encrypted near the end of a
+// key's cryptoperiod still be decrypted afterwards.
+func requireKeyCanDecrypt(w http.ResponseWriter, key *database.Key, now time.Time, graceDays int) bool {
+	if key.NotBefore != nil && now.Before(*key.NotBefore) {
+		middleware.RespondWithError(w, http.StatusConflict, "Key is not yet valid for decryption")
+		return false
+	}
+	if key.NotAfter != nil && now.After(key.NotAfter.AddDate(0, 0, graceDays)) {
+		middleware.RespondWithError(w, http.StatusConflict, "Key has expired and its decryption grace period has ended")
+		return false
+	}
+	return true
+}
+
+// keyMaterialForVersion returns the material of the given key version, where 0 means the current version.
+// On failure it writes the error response and returns false.
+func keyMaterialForVersion(w http.ResponseWriter, key *database.Key, version int) ([]byte, bool) {
+	if version == 0 || version == key.Version {
+		return key.KeyMaterial, true
+	}
+
+	keyVersion, err := database.GetKeyVersion(key.ID, version)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if keyVersion == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Key version not found")
+		return nil, false
+	}
+	return keyVersion.KeyMaterial, true
+}
+
 // EncryptData handles the encryption of data using a specified key
 func EncryptData(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
@@ -55,7 +129,7 @@ func EncryptData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key, err := database.GetKeyByName(req.KeyName, claims.UserID)
+	key, err := database.ResolveKey(req.KeyName, claims.UserID)
 	if err != nil {
 		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
 		return
@@ -64,6 +138,12 @@ func EncryptData(w http.ResponseWriter, r *http.Request) {
 		middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
 		return
 	}
+	if !requireKeyEnabled(w, key) {
+		return
+	}
+	if !requireKeyAlgorithm(w, key, utils.AlgorithmAES256GCM) || !requireKeyCanEncrypt(w, key, time.Now()) {
+		return
+	}
 
 	encryptedData, nonce, err := utils.Encrypt(key.KeyMaterial, []byte(req.Data))
 	if err != nil {
@@ -74,57 +154,71 @@ func EncryptData(w http.ResponseWriter, r *http.Request) {
 	middleware.RespondWithJSON(w, http.StatusOK, EncryptResponse{
 		EncryptedData: utils.EncodeToBase64(encryptedData),
 		Nonce:         utils.EncodeToBase64(nonce),
+		KeyVersion:    key.Version,
 	})
 }
 
 // DecryptData handles the decryption of data using a specified key
-func DecryptData(w http.ResponseWriter, r *http.Request) {
-	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
-	if !ok {
-		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
-		return
-	}
+func DecryptData(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
 
-	var req DecryptRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
+		var req DecryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
 
-	if req.KeyName == "" || req.Data == "" || req.Nonce == "" {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Key name, encrypted data, and nonce are required")
-		return
-	}
+		if req.KeyName == "" || req.Data == "" || req.Nonce == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Key name, encrypted data, and nonce are required")
+			return
+		}
 
-	key, err := database.GetKeyByName(req.KeyName, claims.UserID)
-	if err != nil {
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
-		return
-	}
-	if key == nil {
-		middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
-		return
-	}
+		key, err := database.ResolveKey(req.KeyName, claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if key == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+			return
+		}
+		if !requireKeyEnabled(w, key) {
+			return
+		}
+		if !requireKeyAlgorithm(w, key, utils.AlgorithmAES256GCM) || !requireKeyCanDecrypt(w, key, time.Now(), cfg.KeyDecryptionGraceDays) {
+			return
+		}
 
-	encryptedData, err := utils.DecodeFromBase64(req.Data)
-	if err != nil {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid encrypted data format")
-		return
-	}
+		encryptedData, err := utils.DecodeFromBase64(req.Data)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid encrypted data format")
+			return
+		}
 
-	nonce, err := utils.DecodeFromBase64(req.Nonce)
-	if err != nil {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid nonce format")
-		return
-	}
+		nonce, err := utils.DecodeFromBase64(req.Nonce)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid nonce format")
+			return
+		}
 
-	decryptedData, err := utils.Decrypt(key.KeyMaterial, encryptedData, nonce)
-	if err != nil {
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to decrypt data. Check key, data, and nonce.")
-		return
-	}
+		keyMaterial, ok := keyMaterialForVersion(w, key, req.KeyVersion)
+		if !ok {
+			return
+		}
 
-	middleware.RespondWithJSON(w, http.StatusOK, DecryptResponse{DecryptedData: string(decryptedData)})
+		decryptedData, err := utils.Decrypt(keyMaterial, encryptedData, nonce)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to decrypt data. Check key, data, and nonce.")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, DecryptResponse{DecryptedData: string(decryptedData)})
+	}
 }
 
 // RotateKey handles the rotation of an existing key for the authenticated user
@@ -151,17 +245,19 @@ func RotateKey(w http.ResponseWriter, r *http.Request) {
 		middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
 		return
 	}
+	if !requireKeyEnabled(w, key) {
+		return
+	}
 
-	// Generate new key material
-	newKeyMaterial, err := utils.GenerateAESKey()
+	// Generate new key material of the same algorithm
+	newKeyMaterial, err := utils.GenerateKeyMaterial(key.Algorithm)
 	if err != nil {
 		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate new key material")
 		return
 	}
 
-	key.KeyMaterial = newKeyMaterial
-
-	if err := database.UpdateKey(key); err != nil {
+	// The previous material is archived so data encrypted under it can still be decrypted
+	if err := database.RotateKeyMaterial(key, newKeyMaterial); err != nil {
 		if err == sql.ErrNoRows {
 			middleware.RespondWithError(w, http.StatusNotFound, "Key not found for update or not owned by user")
 			return
@@ -170,11 +266,5 @@ func RotateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keyResp := database.KeyResponse{
-		ID: key.ID,
-		UserID: key.UserID,
-		Name: key.Name,
-		CreatedAt: key.CreatedAt,
-	}
-	middleware.RespondWithJSON(w, http.StatusOK, keyResp)
-}
\ No newline at end of file
+	middleware.RespondWithJSON(w, http.StatusOK, toKeyResponse(key))
+}
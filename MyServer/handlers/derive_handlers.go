@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// Key derivation modes
+const (
+	DeriveModeEncrypt = "encrypt" // Encrypt data with the derived key
+	DeriveModeDecrypt = "decrypt" // Decrypt data previously encrypted with the derived key
+	DeriveModeWrap    = "wrap"    // Return the derived key, encrypted under a wrapping key
+	DeriveModeUnwrap  = "unwrap"  // Decrypt a derived key returned by "wrap"
+)
+
+// derivedKeySize is the length of derived subkeys (AES-256)
+const derivedKeySize = 32
+
+// DeriveRequest defines the request body for deriving a subkey from a stored key
+type DeriveRequest struct {
+	Mode string `json:"mode"` // "encrypt", "decrypt", "wrap" or "unwrap"
+	Salt string `json:"salt"` // Base64-encoded HKDF salt; may be empty
+	Info string `json:"info"` // HKDF context, e.g. "tenant:42/record:7"; required except for "unwrap"
+	// Data is the plaintext for "encrypt", or the base64 ciphertext returned by "encrypt" for "decrypt"
+	Data string `json:"data"`
+	// KeyVersion selects the root key version to derive from; 0 means the current version. For "unwrap",
+	// it selects the wrapping key version instead, as returned by "wrap" in wrapping_key_version.
+	KeyVersion int `json:"key_version,omitempty"`
+	// WrappingKeyName names the key (or alias) that wraps the derived key in "wrap" and "unwrap" modes: an
+	// AES-256-GCM key, or an ML-KEM-768 or X25519-ML-KEM-768 key for post-quantum protection. Defaults to
+	// the root key itself.
+	WrappingKeyName string `json:"wrapping_key_name,omitempty"`
+	// WrappedKey and EncapsulatedKey are the wrapped_key and, for KEM wrapping keys, encapsulated_key
+	// returned by "wrap", for "unwrap"
+	WrappedKey      string `json:"wrapped_key,omitempty"`
+	EncapsulatedKey string `json:"encapsulated_key,omitempty"`
+}
+
+// DeriveResponse defines the response body for key derivation. Which fields are set depends on the mode.
+type DeriveResponse struct {
+	KeyVersion    int    `json:"key_version"`
+	EncryptedData string `json:"encrypted_data,omitempty"` // "encrypt": base64 nonce + ciphertext
+	DecryptedData string `json:"decrypted_data,omitempty"` // "decrypt"
+	WrappedKey    string `json:"wrapped_key,omitempty"`    // "wrap": base64 nonce + wrapped derived key
+	WrappingKeyID int    `json:"wrapping_key_id,omitempty"`
+	// WrappingKeyVersion is the wrapping key version to pass as key_version when unwrapping
+	WrappingKeyVersion int `json:"wrapping_key_version,omitempty"`
+	// EncapsulatedKey is the base64 KEM ciphertext when the wrapping key is a KEM key. The derived key is
+	// wrapped under its shared secret; pass both to /api/kem/decapsulate to unwrap it.
+	EncapsulatedKey string `json:"encapsulated_key,omitempty"`
+	DataKey         string `json:"data_key,omitempty"` // "unwrap": the base64 derived key
+}
+
+// resolveWrappingKey returns the key named by a derive request's wrapping_key_name, or the root key if
+// it is empty, checking it can wrap keys. If it can't, it writes an error response and returns false.
+func resolveWrappingKey(w http.ResponseWriter, root *database.Key, name string) (*database.Key, bool) {
+	if name == "" {
+		return root, true
+	}
+	wrappingKey, err := database.ResolveKey(name, root.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if wrappingKey == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Wrapping key not found or not owned by user")
+		return nil, false
+	}
+	if !requireKeyEnabled(w, wrappingKey) ||
+		!requireKeyAlgorithm(w, wrappingKey, utils.AlgorithmAES256GCM, utils.AlgorithmMLKEM768, utils.AlgorithmX25519MLKEM768) {
+		return nil, false
+	}
+	return wrappingKey, true
+}
+
+// unwrapDerivedKey handles the "unwrap" mode of DeriveKey: decrypting a derived key returned by "wrap"
+// with the wrapping key version it was wrapped under
+func unwrapDerivedKey(cfg *config.Config, w http.ResponseWriter, root *database.Key, req *DeriveRequest, now time.Time) {
+	wrappingKey, ok := resolveWrappingKey(w, root, req.WrappingKeyName)
+	if !ok || !requireKeyCanDecrypt(w, wrappingKey, now, cfg.KeyDecryptionGraceDays) {
+		return
+	}
+	keyMaterial, ok := keyMaterialForVersion(w, wrappingKey, req.KeyVersion)
+	if !ok {
+		return
+	}
+
+	wrappingSecret := keyMaterial
+	if utils.IsKEMAlgorithm(wrappingKey.Algorithm) {
+		if req.EncapsulatedKey == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Encapsulated key is required for a KEM wrapping key")
+			return
+		}
+		encapsulatedKey, err := base64.StdEncoding.DecodeString(req.EncapsulatedKey)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 encapsulated key")
+			return
+		}
+		wrappingSecret, err = utils.KEMDecapsulate(wrappingKey.Algorithm, keyMaterial, encapsulatedKey)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Failed to decapsulate: "+err.Error())
+			return
+		}
+	}
+
+	// A wrong wrapping key, key version or encapsulated key fails authentication here
+	derivedKey, err := utils.DecryptAESGCM(req.WrappedKey, wrappingSecret, cfg.EncryptionNonceSize)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Failed to unwrap derived key. Check wrapping key, key version and wrapped key.")
+		return
+	}
+
+	keyVersion := wrappingKey.Version
+	if req.KeyVersion != 0 {
+		keyVersion = req.KeyVersion
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, DeriveResponse{
+		KeyVersion:    keyVersion, // Of the wrapping key, as in the request
+		WrappingKeyID: wrappingKey.ID,
+		DataKey:       base64.StdEncoding.EncodeToString(derivedKey),
+	})
+}
+
+// DeriveKey handles deriving a per-context subkey from a stored key with HKDF-SHA256 and using it,
+// so that per-record or per-tenant keys never need a row of their own in the keys table. Derived keys
+// returned wrapped by "wrap" are recovered with "unwrap".
+func DeriveKey(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DeriveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Info == "" && req.Mode != DeriveModeUnwrap {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Info is required")
+			return
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(req.Salt)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid salt format")
+			return
+		}
+
+		switch req.Mode {
+		case DeriveModeEncrypt, DeriveModeDecrypt:
+			if req.Data == "" {
+				middleware.RespondWithError(w, http.StatusBadRequest, "Data is required for "+req.Mode+" mode")
+				return
+			}
+		case DeriveModeWrap:
+		case DeriveModeUnwrap:
+			if req.WrappedKey == "" {
+				middleware.RespondWithError(w, http.StatusBadRequest, "Wrapped key is required for unwrap mode")
+				return
+			}
+		default:
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid mode, expected encrypt, decrypt, wrap or unwrap")
+			return
+		}
+
+		key, ok := getKeyFromPath(w, r)
+		if !ok {
+			return
+		}
+		if !requireKeyEnabled(w, key) || !requireKeyAlgorithm(w, key, utils.AlgorithmAES256GCM) {
+			return
+		}
+
+		now := time.Now()
+		if req.Mode == DeriveModeDecrypt || req.Mode == DeriveModeUnwrap {
+			if !requireKeyCanDecrypt(w, key, now, cfg.KeyDecryptionGraceDays) {
+				return
+			}
+		} else if !requireKeyCanEncrypt(w, key, now) {
+			return
+		}
+		if req.Mode == DeriveModeUnwrap {
+			unwrapDerivedKey(cfg, w, key, &req, now)
+			return
+		}
+
+		keyMaterial, ok := keyMaterialForVersion(w, key, req.KeyVersion)
+		if !ok {
+			return
+		}
+
+		derivedKey, err := utils.DeriveKey(keyMaterial, salt, []byte(req.Info), derivedKeySize)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to derive key")
+			return
+		}
+
+		resp := DeriveResponse{KeyVersion: key.Version}
+		if req.KeyVersion != 0 {
+			resp.KeyVersion = req.KeyVersion
+		}
+
+		switch req.Mode {
+		case DeriveModeEncrypt:
+			resp.EncryptedData, err = utils.EncryptAESGCM([]byte(req.Data), derivedKey, cfg.EncryptionNonceSize)
+			if err != nil {
+				middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to encrypt data")
+				return
+			}
+
+		case DeriveModeDecrypt:
+			decryptedData, err := utils.DecryptAESGCM(req.Data, derivedKey, cfg.EncryptionNonceSize)
+			if err != nil {
+				middleware.RespondWithError(w, http.StatusBadRequest, "Failed to decrypt data. Check salt, info, key version and data.")
+				return
+			}
+			resp.DecryptedData = string(decryptedData)
+
+		case DeriveModeWrap:
+			wrappingKey, ok := resolveWrappingKey(w, key, req.WrappingKeyName)
+			if !ok || !requireKeyCanEncrypt(w, wrappingKey, now) {
+				return
+			}
+
+			wrappingSecret := wrappingKey.KeyMaterial
+			if utils.IsKEMAlgorithm(wrappingKey.Algorithm) {
+				publicKey, err := utils.KEMPublicKey(wrappingKey.Algorithm, wrappingKey.KeyMaterial)
+				if err != nil {
+					middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load wrapping key")
+					return
+				}
+				sharedSecret, encapsulatedKey, err := utils.KEMEncapsulate(wrappingKey.Algorithm, publicKey)
+				if err != nil {
+					middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to encapsulate wrapping secret")
+					return
+				}
+				wrappingSecret = sharedSecret
+				resp.EncapsulatedKey = base64.StdEncoding.EncodeToString(encapsulatedKey)
+			}
+
+			resp.WrappedKey, err = utils.EncryptAESGCM(derivedKey, wrappingSecret, cfg.EncryptionNonceSize)
+			if err != nil {
+				middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to wrap derived key")
+				return
+			}
+			resp.WrappingKeyID = wrappingKey.ID
+			resp.WrappingKeyVersion = wrappingKey.Version
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// HPKEOpenRequest defines the request body for opening an HPKE message. Binary fields are base64.
+type HPKEOpenRequest struct {
+	KeyName string `json:"key_name"` // A key name or an alias such as "alias/inbox"
+	// KeyVersion selects the key version the message was sealed to; 0 means the current version
+	KeyVersion int    `json:"key_version,omitempty"`
+	Enc        string `json:"enc"` // The encapsulated key produced by the sender
+	Ciphertext string `json:"ciphertext"`
+	Info       string `json:"info,omitempty"` // Must match the info the message was sealed with
+	AAD        string `json:"aad,omitempty"`  // Must match the aad the message was sealed with
+}
+
+// HPKEOpenResponse defines the response body for an opened HPKE message
+type HPKEOpenResponse struct {
+	Plaintext string `json:"plaintext"` // Base64
+	Suite     string `json:"suite"`
+}
+
+// decodeHPKEField decodes an optional base64 request field
+func decodeHPKEField(value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// OpenHPKE handles decrypting an RFC 9180 HPKE message (base mode, single shot) with one of the
+// authenticated user's X25519 keys. Senders seal messages offline with the exported public key,
+// so the private key never leaves the service.
+func OpenHPKE(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req HPKEOpenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.KeyName == "" || req.Enc == "" || req.Ciphertext == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Key name, enc and ciphertext are required")
+			return
+		}
+		enc, err := base64.StdEncoding.DecodeString(req.Enc)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 enc")
+			return
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(req.Ciphertext)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 ciphertext")
+			return
+		}
+		info, err := decodeHPKEField(req.Info)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 info")
+			return
+		}
+		aad, err := decodeHPKEField(req.AAD)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 aad")
+			return
+		}
+
+		key, err := database.ResolveKey(req.KeyName, claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if key == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+			return
+		}
+		if !requireKeyAlgorithm(w, key, utils.AlgorithmX25519) {
+			return
+		}
+		if !requireKeyEnabled(w, key) || !requireKeyCanDecrypt(w, key, time.Now(), cfg.KeyDecryptionGraceDays) {
+			return
+		}
+		keyMaterial, ok := keyMaterialForVersion(w, key, req.KeyVersion)
+		if !ok {
+			return
+		}
+
+		privateKey, err := utils.ParseX25519PrivateKey(keyMaterial)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load key")
+			return
+		}
+		plaintext, err := utils.HPKEOpen(privateKey, enc, info, aad, ciphertext)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Failed to open HPKE message: "+err.Error())
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, HPKEOpenResponse{
+			Plaintext: base64.StdEncoding.EncodeToString(plaintext),
+			Suite:     utils.HPKESuite,
+		})
+	}
+}
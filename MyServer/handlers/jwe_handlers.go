@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// JWEEncryptRequest defines the request body for encrypting a payload into a compact JWE
+type JWEEncryptRequest struct {
+	KeyName   string `json:"key_name"`      // A key name or an alias such as "alias/partner-x"
+	Algorithm string `json:"alg,omitempty"` // Key management algorithm; defaults to the key's first supported one
+	Plaintext string `json:"plaintext"`
+	// ContentType is copied into the cty header, e.g. "JWT" for nested tokens
+	ContentType string `json:"cty,omitempty"`
+}
+
+// JWEEncryptResponse defines the response body for an encrypted JWE
+type JWEEncryptResponse struct {
+	Token      string `json:"token"`
+	KeyID      string `json:"kid"`
+	Algorithm  string `json:"alg"`
+	Encryption string `json:"enc"`
+}
+
+// JWEDecryptRequest defines the request body for decrypting a compact JWE
+type JWEDecryptRequest struct {
+	Token string `json:"token"`
+	// KeyName selects the decryption key. If omitted, the key is found from the token's kid header.
+	KeyName string `json:"key_name,omitempty"`
+}
+
+// JWEDecryptResponse defines the response body for a decrypted JWE
+type JWEDecryptResponse struct {
+	Plaintext   string `json:"plaintext"`
+	KeyID       string `json:"kid,omitempty"`
+	Algorithm   string `json:"alg"`
+	ContentType string `json:"cty,omitempty"`
+}
+
+// EncryptJWE handles encrypting a payload into a compact JWE (A256GCM content encryption) with one of
+// the authenticated user's keys: dir or A256KW for AES keys, RSA-OAEP-256 for RSA keys and
+// ECDH-ES+A256KW for EC keys
+func EncryptJWE(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	var req JWEEncryptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.KeyName == "" || req.Plaintext == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key name and plaintext are required")
+		return
+	}
+
+	key, err := database.ResolveKey(req.KeyName, claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if key == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+		return
+	}
+	if !requireKeyEnabled(w, key) || !requireKeyCanEncrypt(w, key, time.Now()) {
+		return
+	}
+
+	algorithms := utils.JWEAlgorithms(key.Algorithm)
+	if len(algorithms) == 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key algorithm "+key.Algorithm+" does not support this operation")
+		return
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = algorithms[0]
+	}
+
+	kid := jwtKeyID(key.ID, key.Version)
+	token, err := utils.EncryptJWE([]byte(req.Plaintext), req.Algorithm, kid, req.ContentType, key.Algorithm, key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Failed to encrypt JWE: "+err.Error())
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, JWEEncryptResponse{
+		Token:      token,
+		KeyID:      kid,
+		Algorithm:  req.Algorithm,
+		Encryption: utils.JWEEncA256GCM,
+	})
+}
+
+// DecryptJWE handles decrypting a compact JWE with one of the authenticated user's keys.
+// A kid header produced by EncryptJWE also selects the key version, so tokens encrypted
+// before a rotation still decrypt.
+func DecryptJWE(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req JWEDecryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Token == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Token is required")
+			return
+		}
+
+		header, err := utils.ParseJWEHeader(req.Token)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid JWE: "+err.Error())
+			return
+		}
+		kidKeyID, kidVersion, kidErr := parseJWTKeyID(header.KeyID)
+
+		var key *database.Key
+		if req.KeyName != "" {
+			key, err = database.ResolveKey(req.KeyName, claims.UserID)
+		} else if kidErr == nil {
+			key, err = database.GetKeyByID(kidKeyID, claims.UserID)
+		} else {
+			middleware.RespondWithError(w, http.StatusBadRequest, "JWE has no usable kid header; key_name is required")
+			return
+		}
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if key == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+			return
+		}
+		if !requireKeyEnabled(w, key) || !requireKeyCanDecrypt(w, key, time.Now(), cfg.KeyDecryptionGraceDays) {
+			return
+		}
+
+		version := 0
+		if kidErr == nil && kidKeyID == key.ID {
+			version = kidVersion
+		}
+		keyMaterial, ok := keyMaterialForVersion(w, key, version)
+		if !ok {
+			return
+		}
+
+		plaintext, err := utils.DecryptJWE(req.Token, key.Algorithm, keyMaterial)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Failed to decrypt JWE: "+err.Error())
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, JWEDecryptResponse{
+			Plaintext:   string(plaintext),
+			KeyID:       header.KeyID,
+			Algorithm:   header.Algorithm,
+			ContentType: header.ContentType,
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/gorilla/mux"
+)
+
+// jwksMaxAge is how long, in seconds, relying parties may cache a JWKS response
+const jwksMaxAge = "300"
+
+// GetJWKS handles serving the public keys of every published key, across all users
+func GetJWKS(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJWKS(w, nil, cfg.JWKSRotationGraceDays)
+	}
+}
+
+// GetOwnerJWKS handles serving the public keys of the keys published by the user named in the {owner} path variable.
+// An unknown owner gets an empty set, the same as an owner with nothing published, so usernames can't be probed.
+func GetOwnerJWKS(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, err := database.GetUserByUsername(mux.Vars(r)["owner"])
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if owner == nil {
+			w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+			middleware.RespondWithJSON(w, http.StatusOK, utils.JWKSet{Keys: []utils.JWK{}})
+			return
+		}
+		respondWithJWKS(w, &owner.ID, cfg.JWKSRotationGraceDays)
+	}
+}
+
+// respondWithJWKS writes the JWKS of the published keys of userID (or of all users if nil),
+// including versions rotated out within the last graceDays
+func respondWithJWKS(w http.ResponseWriter, userID *int, graceDays int) {
+	versions, err := database.GetPublishedKeyVersions(userID, time.Now().AddDate(0, 0, -graceDays))
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	set := utils.JWKSet{Keys: []utils.JWK{}}
+	for _, v := range versions {
+		jwk, err := publicJWK(v)
+		if err != nil {
+			// Skip keys that can't be listed rather than failing the whole set for every relying party
+			log.Printf("Error building JWK for key %d version %d: %v", v.KeyID, v.Version, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	middleware.RespondWithJSON(w, http.StatusOK, set)
+}
+
+// publicJWK builds the JWK of a published key version, with the kid and alg that SignJWT puts in token headers.
+// X25519 keys can't sign, so they are listed for encryption (HPKE) without an alg.
+func publicJWK(v database.PublishedKeyVersion) (utils.JWK, error) {
+	publicKey, err := utils.PublicKey(v.Algorithm, v.KeyMaterial)
+	if err != nil {
+		return utils.JWK{}, err
+	}
+	if v.Algorithm == utils.AlgorithmX25519 {
+		return utils.PublicJWK(publicKey, jwtKeyID(v.KeyID, v.Version), "", "enc")
+	}
+	method, err := utils.JWTSigningMethod(v.Algorithm)
+	if err != nil {
+		return utils.JWK{}, err
+	}
+	return utils.PublicJWK(publicKey, jwtKeyID(v.KeyID, v.Version), method.Alg(), "sig")
+}
+
+// GetSessionJWKS handles serving the public keys that sign magicgate's own access tokens, so other
+// services can verify them. Keys are listed before they start signing and until the tokens they
+// signed have expired, so verifiers refreshing the set at least every few minutes never miss a kid.
+func GetSessionJWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := database.GetSessionSigningKeys(time.Now())
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	set := utils.JWKSet{Keys: []utils.JWK{}}
+	for _, key := range keys {
+		jwk, err := sessionJWK(key)
+		if err != nil {
+			log.Printf("Error building JWK for session signing key %s: %v", key.KeyID, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	middleware.RespondWithJSON(w, http.StatusOK, set)
+}
+
+// sessionJWK builds the JWK of a session signing key
+func sessionJWK(key database.SessionSigningKey) (utils.JWK, error) {
+	publicKey, err := utils.PublicKey(key.Algorithm, key.PrivateKey)
+	if err != nil {
+		return utils.JWK{}, err
+	}
+	method, err := utils.JWTSigningMethod(key.Algorithm)
+	if err != nil {
+		return utils.JWK{}, err
+	}
+	return utils.PublicJWK(publicKey, key.KeyID, method.Alg(), "sig")
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTSignRequest defines the request body for signing a token with a stored key
+type JWTSignRequest struct {
+	Claims map[string]interface{} `json:"claims"`
+	// ExpiresIn sets the exp claim this many seconds from now, unless claims already has one
+	ExpiresIn int `json:"expires_in,omitempty"`
+}
+
+// JWTSignResponse defines the response body for a signed token
+type JWTSignResponse struct {
+	Token     string `json:"token"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+}
+
+// JWTVerifyRequest defines the request body for verifying a token against the caller's keys
+type JWTVerifyRequest struct {
+	Token    string `json:"token"`
+	Audience string `json:"audience,omitempty"` // If set, the aud claim must contain it
+	Issuer   string `json:"issuer,omitempty"`   // If set, the iss claim must equal it
+}
+
+// JWTVerifyResponse defines the response body for token verification
+type JWTVerifyResponse struct {
+	Valid     bool                   `json:"valid"`
+	Claims    map[string]interface{} `json:"claims,omitempty"`
+	KeyID     string                 `json:"kid,omitempty"`
+	Algorithm string                 `json:"alg,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// jwtKeyID builds the kid header value identifying a version of a stored key
+func jwtKeyID(keyID, version int) string {
+	return fmt.Sprintf("%d-v%d", keyID, version)
+}
+
+// parseJWTKeyID parses a kid header value produced by jwtKeyID
+func parseJWTKeyID(kid string) (keyID, version int, err error) {
+	var rest string
+	if n, _ := fmt.Sscanf(kid, "%d-v%d%s", &keyID, &version, &rest); n != 2 {
+		return 0, 0, fmt.Errorf("malformed kid %q", kid)
+	}
+	return keyID, version, nil
+}
+
+// SignJWT handles signing arbitrary claims into a JWT with one of the authenticated user's keys
+func SignJWT(w http.ResponseWriter, r *http.Request) {
+	var req JWTSignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.Claims == nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Claims are required")
+		return
+	}
+	if req.ExpiresIn < 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "expires_in cannot be negative")
+		return
+	}
+
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireKeyEnabled(w, key) {
+		return
+	}
+
+	method, err := utils.JWTSigningMethod(key.Algorithm)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key algorithm "+key.Algorithm+" does not support this operation")
+		return
+	}
+
+	now := time.Now()
+	if !requireKeyCanEncrypt(w, key, now) {
+		return
+	}
+
+	claims := jwt.MapClaims(req.Claims)
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = now.Unix()
+	}
+	if _, ok := claims["exp"]; !ok && req.ExpiresIn > 0 {
+		claims["exp"] = now.Add(time.Duration(req.ExpiresIn) * time.Second).Unix()
+	}
+
+	kid := jwtKeyID(key.ID, key.Version)
+	token, err := utils.SignJWTWithKey(claims, kid, key.Algorithm, key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to sign token")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, JWTSignResponse{Token: token, KeyID: kid, Algorithm: method.Alg()})
+}
+
+// VerifyJWT handles verifying a JWT signed by one of the authenticated user's keys.
+// The key is selected by the token's kid header, and the token's alg must match that key.
+// Tokens that fail verification get a 200 response with valid set to false and the reason in error.
+func VerifyJWT(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req JWTVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Token == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Token is required")
+			return
+		}
+
+		opts := []jwt.ParserOption{}
+		if req.Audience != "" {
+			opts = append(opts, jwt.WithAudience(req.Audience))
+		}
+		if req.Issuer != "" {
+			opts = append(opts, jwt.WithIssuer(req.Issuer))
+		}
+
+		var dbErr error
+		tokenClaims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(req.Token, tokenClaims, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			keyID, version, err := parseJWTKeyID(kid)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := database.GetKeyByID(keyID, claims.UserID)
+			if err != nil {
+				dbErr = err
+				return nil, err
+			}
+			if key == nil {
+				return nil, errors.New("unknown signing key")
+			}
+			if key.State != database.KeyStateEnabled {
+				return nil, errors.New("signing key is " + key.State)
+			}
+			now := time.Now()
+			if key.NotBefore != nil && now.Before(*key.NotBefore) ||
+				key.NotAfter != nil && now.After(key.NotAfter.AddDate(0, 0, cfg.KeyDecryptionGraceDays)) {
+				return nil, errors.New("signing key is outside its validity period")
+			}
+
+			method, err := utils.JWTSigningMethod(key.Algorithm)
+			if err != nil {
+				return nil, err
+			}
+			// Pin the algorithm to the key's, so a token can't pick a weaker or different one
+			if token.Method.Alg() != method.Alg() {
+				return nil, fmt.Errorf("token alg %s does not match key alg %s", token.Method.Alg(), method.Alg())
+			}
+
+			material := key.KeyMaterial
+			if version != key.Version {
+				keyVersion, err := database.GetKeyVersion(key.ID, version)
+				if err != nil {
+					dbErr = err
+					return nil, err
+				}
+				if keyVersion == nil {
+					return nil, errors.New("unknown signing key version")
+				}
+				material = keyVersion.KeyMaterial
+			}
+			return utils.JWTVerificationKey(key.Algorithm, material)
+		}, opts...)
+
+		if dbErr != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if err != nil {
+			middleware.RespondWithJSON(w, http.StatusOK, JWTVerifyResponse{Valid: false, Error: err.Error()})
+			return
+		}
+		if !token.Valid {
+			middleware.RespondWithJSON(w, http.StatusOK, JWTVerifyResponse{Valid: false, Error: "token is invalid"})
+			return
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		middleware.RespondWithJSON(w, http.StatusOK, JWTVerifyResponse{
+			Valid:     true,
+			Claims:    tokenClaims,
+			KeyID:     kid,
+			Algorithm: token.Method.Alg(),
+		})
+	}
+}
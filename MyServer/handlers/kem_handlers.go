@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// KEMEncapsulateRequest defines the request body for encapsulating a shared secret to a KEM key
+type KEMEncapsulateRequest struct {
+	KeyName string `json:"key_name"` // A key name or an alias such as "alias/archive"
+}
+
+// KEMEncapsulateResponse defines the response body for an encapsulated shared secret. Binary fields are base64.
+type KEMEncapsulateResponse struct {
+	SharedSecret string `json:"shared_secret"`
+	Ciphertext   string `json:"ciphertext"` // Store next to the data; decapsulating it recovers shared_secret
+	KeyVersion   int    `json:"key_version"`
+	Algorithm    string `json:"algorithm"`
+}
+
+// KEMDecapsulateRequest defines the request body for decapsulating a shared secret
+type KEMDecapsulateRequest struct {
+	KeyName string `json:"key_name"` // A key name or an alias such as "alias/archive"
+	// KeyVersion selects the key version the secret was encapsulated to; 0 means the current version
+	KeyVersion int    `json:"key_version,omitempty"`
+	Ciphertext string `json:"ciphertext"` // Base64
+	// WrappedKey is a wrapped_key returned by /api/keys/{id}/derive in wrap mode. If given, it is
+	// unwrapped with the shared secret and the data key is returned instead of the secret.
+	WrappedKey string `json:"wrapped_key,omitempty"`
+}
+
+// KEMDecapsulateResponse defines the response body for a decapsulated shared secret. Binary fields are base64.
+type KEMDecapsulateResponse struct {
+	SharedSecret string `json:"shared_secret,omitempty"`
+	DataKey      string `json:"data_key,omitempty"` // Set instead of shared_secret when wrapped_key was given
+}
+
+// resolveKEMKey loads the authenticated user's KEM key named keyName (or an alias).
+// On failure it writes the error response and returns false.
+func resolveKEMKey(w http.ResponseWriter, r *http.Request, keyName string) (*database.Key, bool) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return nil, false
+	}
+
+	key, err := database.ResolveKey(keyName, claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if key == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+		return nil, false
+	}
+	if !requireKeyAlgorithm(w, key, utils.AlgorithmMLKEM768, utils.AlgorithmX25519MLKEM768) || !requireKeyEnabled(w, key) {
+		return nil, false
+	}
+	return key, true
+}
+
+// EncapsulateKey handles generating a fresh shared secret encapsulated to one of the authenticated
+// user's ML-KEM-768 or X25519-ML-KEM-768 keys, at its current version. The secret can key a data
+// encryption key; only a decapsulation with the same key recovers it.
+func EncapsulateKey(w http.ResponseWriter, r *http.Request) {
+	var req KEMEncapsulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.KeyName == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key name is required")
+		return
+	}
+
+	key, ok := resolveKEMKey(w, r, req.KeyName)
+	if !ok {
+		return
+	}
+	if !requireKeyCanEncrypt(w, key, time.Now()) {
+		return
+	}
+
+	publicKey, err := utils.KEMPublicKey(key.Algorithm, key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load key")
+		return
+	}
+	sharedSecret, ciphertext, err := utils.KEMEncapsulate(key.Algorithm, publicKey)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to encapsulate shared secret")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, KEMEncapsulateResponse{
+		SharedSecret: base64.StdEncoding.EncodeToString(sharedSecret),
+		Ciphertext:   base64.StdEncoding.EncodeToString(ciphertext),
+		KeyVersion:   key.Version,
+		Algorithm:    key.Algorithm,
+	})
+}
+
+// DecapsulateKey handles recovering a shared secret encapsulated to one of the authenticated user's
+// KEM keys, or the data key it wraps
+func DecapsulateKey(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req KEMDecapsulateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.KeyName == "" || req.Ciphertext == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Key name and ciphertext are required")
+			return
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(req.Ciphertext)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 ciphertext")
+			return
+		}
+
+		key, ok := resolveKEMKey(w, r, req.KeyName)
+		if !ok {
+			return
+		}
+		if !requireKeyCanDecrypt(w, key, time.Now(), cfg.KeyDecryptionGraceDays) {
+			return
+		}
+		keyMaterial, ok := keyMaterialForVersion(w, key, req.KeyVersion)
+		if !ok {
+			return
+		}
+
+		sharedSecret, err := utils.KEMDecapsulate(key.Algorithm, keyMaterial, ciphertext)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Failed to decapsulate: "+err.Error())
+			return
+		}
+
+		if req.WrappedKey == "" {
+			middleware.RespondWithJSON(w, http.StatusOK, KEMDecapsulateResponse{
+				SharedSecret: base64.StdEncoding.EncodeToString(sharedSecret),
+			})
+			return
+		}
+
+		// A wrong ciphertext or key version decapsulates to a different secret, which fails authentication here
+		dataKey, err := utils.DecryptAESGCM(req.WrappedKey, sharedSecret, cfg.EncryptionNonceSize)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Failed to unwrap data key. Check ciphertext, key version and wrapped key.")
+			return
+		}
+		middleware.RespondWithJSON(w, http.StatusOK, KEMDecapsulateResponse{
+			DataKey: base64.StdEncoding.EncodeToString(dataKey),
+		})
+	}
+}
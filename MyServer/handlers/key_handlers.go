@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"crypto/ecdh"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/anurag/magicgate/MyServer/config"
 	"github.com/anurag/magicgate/MyServer/database"
 	"github.com/anurag/magicgate/MyServer/middleware"
 	"github.com/anurag/magicgate/MyServer/utils"
@@ -14,12 +19,201 @@ import (
 
 // KeyCreateRequest defines the request body for creating a key
 type KeyCreateRequest struct {
-	Name string `json:"name"`
+	Name           string             `json:"name"`
+	Algorithm      string             `json:"algorithm"` // Defaults to AES-256-GCM
+	Description    string             `json:"description"`
+	Tags           []string           `json:"tags"`
+	Labels         database.KeyLabels `json:"labels"`
+	RotationPeriod int                `json:"rotation_period"` // In days; 0 disables automatic rotation
+	NotBefore      *time.Time         `json:"not_before"`      // RFC 3339; no encryption before this time
+	NotAfter       *time.Time         `json:"not_after"`       // RFC 3339; no encryption after this time
+	Publish        bool               `json:"publish"`         // List the public key in the JWKS endpoints
 }
 
-// KeyUpdateRequest defines the request body for updating a key
+// KeyUpdateRequest defines the request body for updating a key.
+// Fields left out of the request are not changed.
 type KeyUpdateRequest struct {
-	Name string `json:"name"`
+	Name           string              `json:"name"`
+	Description    *string             `json:"description"`
+	Tags           *[]string           `json:"tags"`
+	Labels         *database.KeyLabels `json:"labels"`
+	RotationPeriod *int                `json:"rotation_period"`
+	NotBefore      json.RawMessage     `json:"not_before"` // RFC 3339, or null to remove the bound
+	NotAfter       json.RawMessage     `json:"not_after"`  // RFC 3339, or null to remove the bound
+	Publish        *bool               `json:"publish"`
+}
+
+// updateNullableTime applies a KeyUpdateRequest time field to *dst: an omitted field leaves it unchanged,
+// null clears it, and an RFC 3339 timestamp replaces it
+func updateNullableTime(dst **time.Time, raw json.RawMessage) error {
+	if raw == nil {
+		return nil
+	}
+	if string(raw) == "null" {
+		*dst = nil
+		return nil
+	}
+	var t time.Time
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return err
+	}
+	*dst = &t
+	return nil
+}
+
+// PublicKeyResponse defines the response body for exporting the public half of an asymmetric key
+type PublicKeyResponse struct {
+	KeyID        int    `json:"key_id"`
+	Algorithm    string `json:"algorithm"`
+	Version      int    `json:"version"`
+	PublicKeyPEM string `json:"public_key_pem,omitempty"` // PEM SubjectPublicKeyInfo; not set for KEM keys
+	// PublicKey is the raw public key in base64, for X25519 keys as HPKE libraries take it, and for
+	// KEM keys as their encapsulation key
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// KeyListResponse defines the response body for listing keys
+type KeyListResponse struct {
+	Keys       []database.KeyResponse `json:"keys"`
+	NextCursor string                 `json:"next_cursor,omitempty"` // Pass as ?cursor= to fetch the next page
+}
+
+// Key listing page sizes
+const (
+	defaultKeyPageSize = 100
+	maxKeyPageSize     = 1000
+)
+
+// keyCursor is the decoded form of the opaque pagination cursor returned by GetAllKeys
+type keyCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// encodeKeyCursor builds the cursor pointing just past key for the given sort order
+func encodeKeyCursor(sort string, key *database.Key) string {
+	cursor := keyCursor{Sort: sort, ID: key.ID, Value: key.Name}
+	if strings.TrimPrefix(sort, "-") == database.KeySortCreatedAt {
+		cursor.Value = key.CreatedAt.Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeKeyCursor parses a cursor produced by encodeKeyCursor
+func decodeKeyCursor(encoded string) (*keyCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &keyCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// validateKeyValidity checks that a key's validity window, if bounded on both ends, isn't empty
+func validateKeyValidity(notBefore, notAfter *time.Time) string {
+	if notBefore != nil && notAfter != nil && !notAfter.After(*notBefore) {
+		return "not_after must be later than not_before"
+	}
+	return ""
+}
+
+// validateKeyMetadata checks the free-form metadata accepted on key create and update
+func validateKeyMetadata(tags []string, labels database.KeyLabels) string {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return "Tags cannot be empty"
+		}
+	}
+	for name := range labels {
+		if strings.TrimSpace(name) == "" {
+			return "Label names cannot be empty"
+		}
+	}
+	return ""
+}
+
+// validateKeyPublish checks that only keys with a public half are published
+func validateKeyPublish(algorithm string, publish bool) string {
+	if publish && !utils.IsAsymmetricAlgorithm(algorithm) {
+		return "Only asymmetric keys can be published, not " + algorithm + " keys"
+	}
+	return ""
+}
+
+// supportedKeyAlgorithms lists the algorithms keys can be created with
+var supportedKeyAlgorithms = []string{
+	utils.AlgorithmAES256GCM,
+	utils.AlgorithmHMACSHA256, utils.AlgorithmHMACSHA384, utils.AlgorithmHMACSHA512,
+	utils.AlgorithmRSA2048, utils.AlgorithmRSA3072, utils.AlgorithmRSA4096,
+	utils.AlgorithmECP256, utils.AlgorithmECP384, utils.AlgorithmECP521,
+	utils.AlgorithmEd25519, utils.AlgorithmX25519,
+	utils.AlgorithmMLKEM768, utils.AlgorithmX25519MLKEM768,
+}
+
+// isSupportedKeyAlgorithm reports whether keys can be created with the given algorithm
+func isSupportedKeyAlgorithm(algorithm string) bool {
+	for _, supported := range supportedKeyAlgorithms {
+		if algorithm == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// toKeyResponse converts a Key into a KeyResponse, leaving out the raw key material
+func toKeyResponse(key *database.Key) database.KeyResponse {
+	return database.KeyResponse{
+		ID:             key.ID,
+		UserID:         key.UserID,
+		Name:           key.Name,
+		Algorithm:      key.Algorithm,
+		State:          key.State,
+		Description:    key.Description,
+		Tags:           key.Tags,
+		Labels:         key.Labels,
+		Version:        key.Version,
+		RotationPeriod: key.RotationPeriod,
+		LastRotatedAt:  key.LastRotatedAt,
+		NextRotationAt: key.NextRotationAt,
+
+		ScheduledDeletionAt: key.ScheduledDeletionAt,
+		NotBefore:           key.NotBefore,
+		NotAfter:            key.NotAfter,
+		Publish:             key.Publish,
+		CreatedAt:           key.CreatedAt,
+	}
+}
+
+// getKeyFromPath loads the authenticated user's key identified by the {id} path variable.
+// On failure it writes the error response and returns false.
+func getKeyFromPath(w http.ResponseWriter, r *http.Request) (*database.Key, bool) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid key ID")
+		return nil, false
+	}
+
+	key, err := database.GetKeyByID(id, claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if key == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+		return nil, false
+	}
+	return key, true
 }
 
 // CreateKey handles the creation of a new cryptographic key for the authenticated user
@@ -41,17 +235,57 @@ func CreateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate a new AES key
-	keyMaterial, err := utils.GenerateAESKey()
+	if database.IsAliasName(req.Name) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key name cannot start with \""+database.AliasPrefix+"\"")
+		return
+	}
+
+	if req.RotationPeriod < 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Rotation period cannot be negative")
+		return
+	}
+
+	if msg := validateKeyMetadata(req.Tags, req.Labels); msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if msg := validateKeyValidity(req.NotBefore, req.NotAfter); msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if req.Algorithm == "" {
+		req.Algorithm = utils.AlgorithmAES256GCM
+	}
+	if !isSupportedKeyAlgorithm(req.Algorithm) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Unsupported key algorithm: "+req.Algorithm)
+		return
+	}
+
+	if msg := validateKeyPublish(req.Algorithm, req.Publish); msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	keyMaterial, err := utils.GenerateKeyMaterial(req.Algorithm)
 	if err != nil {
 		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate key material")
 		return
 	}
 
 	key := &database.Key{
-		UserID:      claims.UserID,
-		Name:        req.Name,
-		KeyMaterial: keyMaterial,
+		UserID:         claims.UserID,
+		Name:           req.Name,
+		Algorithm:      req.Algorithm,
+		Description:    req.Description,
+		Tags:           req.Tags,
+		Labels:         req.Labels,
+		KeyMaterial:    keyMaterial,
+		RotationPeriod: req.RotationPeriod,
+		NotBefore:      req.NotBefore,
+		NotAfter:       req.NotAfter,
+		Publish:        req.Publish,
 	}
 
 	if err := database.CreateKey(key); err != nil {
@@ -60,13 +294,7 @@ func CreateKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Respond with KeyResponse to avoid exposing raw key material
-	keyResp := database.KeyResponse{
-		ID:        key.ID,
-		UserID:    key.UserID,
-		Name:      key.Name,
-		CreatedAt: key.CreatedAt,
-	}
-	middleware.RespondWithJSON(w, http.StatusCreated, keyResp)
+	middleware.RespondWithJSON(w, http.StatusCreated, toKeyResponse(key))
 }
 
 // GetKey handles retrieving a specific key for the authenticated user
@@ -94,16 +322,56 @@ func GetKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keyResp := database.KeyResponse{
-		ID:        key.ID,
-		UserID:    key.UserID,
-		Name:      key.Name,
-		CreatedAt: key.CreatedAt,
+	middleware.RespondWithJSON(w, http.StatusOK, toKeyResponse(key))
+}
+
+// GetPublicKey handles exporting the public half of one of the authenticated user's asymmetric or KEM keys,
+// at its current version. Anyone holding it can verify signatures, or seal HPKE messages (X25519 keys)
+// and encapsulate secrets (KEM keys) offline that only this service can open.
+func GetPublicKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return
 	}
-	middleware.RespondWithJSON(w, http.StatusOK, keyResp)
+	resp := PublicKeyResponse{KeyID: key.ID, Algorithm: key.Algorithm, Version: key.Version}
+
+	// KEM keys have no standard SubjectPublicKeyInfo encoding yet
+	if utils.IsKEMAlgorithm(key.Algorithm) {
+		publicKey, err := utils.KEMPublicKey(key.Algorithm, key.KeyMaterial)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load public key")
+			return
+		}
+		resp.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+		middleware.RespondWithJSON(w, http.StatusOK, resp)
+		return
+	}
+	if !utils.IsAsymmetricAlgorithm(key.Algorithm) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key algorithm "+key.Algorithm+" has no public key")
+		return
+	}
+
+	publicKey, err := utils.PublicKey(key.Algorithm, key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load public key")
+		return
+	}
+	publicKeyPEM, err := utils.EncodePublicKeyPEM(publicKey)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to encode public key")
+		return
+	}
+
+	resp.PublicKeyPEM = publicKeyPEM
+	if x25519Key, ok := publicKey.(*ecdh.PublicKey); ok {
+		resp.PublicKey = base64.StdEncoding.EncodeToString(x25519Key.Bytes())
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
 }
 
-// GetAllKeys handles retrieving all keys for the authenticated user
+// GetAllKeys handles listing the authenticated user's keys.
+// Supported query parameters: tag (repeatable), label=name:value (repeatable), algorithm, state,
+// name_prefix, sort (name, created_at, or either prefixed with "-" for descending), limit and cursor.
 func GetAllKeys(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
 	if !ok {
@@ -111,25 +379,85 @@ func GetAllKeys(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keys, err := database.GetAllKeysForUser(claims.UserID)
+	query := r.URL.Query()
+	opts := database.KeyListOptions{
+		Tags:       query["tag"],
+		Algorithm:  query.Get("algorithm"),
+		State:      query.Get("state"),
+		NamePrefix: query.Get("name_prefix"),
+		Limit:      defaultKeyPageSize,
+	}
+
+	for _, label := range query["label"] {
+		name, value, found := strings.Cut(label, ":")
+		if !found || name == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid label filter, expected name:value")
+			return
+		}
+		if opts.Labels == nil {
+			opts.Labels = database.KeyLabels{}
+		}
+		opts.Labels[name] = value
+	}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = database.KeySortCreatedAt
+	}
+	opts.Descending = strings.HasPrefix(sort, "-")
+	opts.SortBy = strings.TrimPrefix(sort, "-")
+	if opts.SortBy != database.KeySortCreatedAt && opts.SortBy != database.KeySortName {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid sort, expected name or created_at")
+		return
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxKeyPageSize {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid limit, expected 1 to "+strconv.Itoa(maxKeyPageSize))
+			return
+		}
+		opts.Limit = limit
+	}
+
+	if encoded := query.Get("cursor"); encoded != "" {
+		cursor, err := decodeKeyCursor(encoded)
+		if err != nil || cursor.Sort != sort {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid cursor for this sort order")
+			return
+		}
+		opts.AfterValue = cursor.Value
+		opts.AfterID = cursor.ID
+	}
+
+	keys, hasMore, err := database.ListKeysForUser(claims.UserID, opts)
 	if err != nil {
 		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	keyResponses := make([]database.KeyResponse, len(keys))
-	for i, key := range keys {
-		keyResponses[i] = database.KeyResponse{
-			ID:        key.ID,
-			UserID:    key.UserID,
-			Name:      key.Name,
-			CreatedAt: key.CreatedAt,
-		}
+	aliases, err := database.GetAllAliasesForUser(claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
 	}
-	middleware.RespondWithJSON(w, http.StatusOK, keyResponses)
+	aliasesByKey := make(map[int][]string)
+	for _, alias := range aliases {
+		aliasesByKey[alias.KeyID] = append(aliasesByKey[alias.KeyID], alias.Name)
+	}
+
+	resp := KeyListResponse{Keys: make([]database.KeyResponse, len(keys))}
+	for i := range keys {
+		resp.Keys[i] = toKeyResponse(&keys[i])
+		resp.Keys[i].Aliases = aliasesByKey[keys[i].ID]
+	}
+	if hasMore {
+		resp.NextCursor = encodeKeyCursor(sort, &keys[len(keys)-1])
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
 }
 
-// UpdateKey handles updating a key's name for the authenticated user
+// UpdateKey handles updating a key's name, metadata or rotation period for the authenticated user
 func UpdateKey(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
 	if !ok {
@@ -150,8 +478,32 @@ func UpdateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name == "" {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Key name is required for update")
+	if req.Name == "" && req.Description == nil && req.Tags == nil && req.Labels == nil && req.RotationPeriod == nil &&
+		req.NotBefore == nil && req.NotAfter == nil && req.Publish == nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "At least one field is required for update")
+		return
+	}
+
+	if database.IsAliasName(req.Name) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Key name cannot start with \""+database.AliasPrefix+"\"")
+		return
+	}
+
+	if req.RotationPeriod != nil && *req.RotationPeriod < 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Rotation period cannot be negative")
+		return
+	}
+
+	var tags []string
+	var labels database.KeyLabels
+	if req.Tags != nil {
+		tags = *req.Tags
+	}
+	if req.Labels != nil {
+		labels = *req.Labels
+	}
+	if msg := validateKeyMetadata(tags, labels); msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
 		return
 	}
 
@@ -165,9 +517,41 @@ func UpdateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key.Name = req.Name
-	// Note: KeyMaterial is not updated via this endpoint for simplicity.
-	// A separate endpoint or flow might be needed for key rotation/regeneration.
+	if req.Name != "" {
+		key.Name = req.Name
+	}
+	if req.Description != nil {
+		key.Description = *req.Description
+	}
+	if req.Tags != nil {
+		key.Tags = *req.Tags
+	}
+	if req.Labels != nil {
+		key.Labels = *req.Labels
+	}
+	if req.RotationPeriod != nil {
+		key.RotationPeriod = *req.RotationPeriod
+	}
+	if err := updateNullableTime(&key.NotBefore, req.NotBefore); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "not_before must be an RFC 3339 timestamp or null")
+		return
+	}
+	if err := updateNullableTime(&key.NotAfter, req.NotAfter); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "not_after must be an RFC 3339 timestamp or null")
+		return
+	}
+	if msg := validateKeyValidity(key.NotBefore, key.NotAfter); msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if req.Publish != nil {
+		if msg := validateKeyPublish(key.Algorithm, *req.Publish); msg != "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, msg)
+			return
+		}
+		key.Publish = *req.Publish
+	}
+	// Note: KeyMaterial is not updated via this endpoint; use POST /api/keys/{id}/rotate instead.
 
 	if err := database.UpdateKey(key); err != nil {
 		if err == sql.ErrNoRows {
@@ -178,39 +562,91 @@ func UpdateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keyResp := database.KeyResponse{
-		ID:        key.ID,
-		UserID:    key.UserID,
-		Name:      key.Name,
-		CreatedAt: key.CreatedAt,
+	middleware.RespondWithJSON(w, http.StatusOK, toKeyResponse(key))
+}
+
+// DeleteKey handles scheduling a key for deletion for the authenticated user.
+// The key is destroyed after a waiting period, given in days by the optional waiting_days
+// query parameter (7-30), and can be recovered with CancelKeyDeletion until then.
+func DeleteKey(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		waitingDays := cfg.KeyDeletionWaitingDays
+		if waitingDaysStr := r.URL.Query().Get("waiting_days"); waitingDaysStr != "" {
+			days, err := strconv.Atoi(waitingDaysStr)
+			if err != nil || days < config.MinKeyDeletionWaitingDays || days > config.MaxKeyDeletionWaitingDays {
+				middleware.RespondWithError(w, http.StatusBadRequest, "Invalid waiting_days, expected "+
+					strconv.Itoa(config.MinKeyDeletionWaitingDays)+" to "+strconv.Itoa(config.MaxKeyDeletionWaitingDays))
+				return
+			}
+			waitingDays = days
+		}
+
+		key, ok := getKeyFromPath(w, r)
+		if !ok {
+			return
+		}
+		if key.State == database.KeyStatePendingDeletion {
+			middleware.RespondWithError(w, http.StatusConflict, "Key is already pending deletion")
+			return
+		}
+
+		if err := database.ScheduleKeyDeletion(key, time.Now().AddDate(0, 0, waitingDays)); err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to schedule key deletion")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, toKeyResponse(key))
 	}
-	middleware.RespondWithJSON(w, http.StatusOK, keyResp)
 }
 
-// DeleteKey handles deleting a key for the authenticated user
-func DeleteKey(w http.ResponseWriter, r *http.Request) {
-	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+// CancelKeyDeletion handles cancelling a scheduled key deletion. The key is left disabled.
+func CancelKeyDeletion(w http.ResponseWriter, r *http.Request) {
+	key, ok := getKeyFromPath(w, r)
 	if !ok {
-		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
 		return
 	}
 
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
-	if err != nil {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid key ID")
+	if err := database.CancelKeyDeletion(key); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusConflict, "Key is not pending deletion")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to cancel key deletion")
 		return
 	}
 
-	err = database.DeleteKey(id, claims.UserID)
-	if err != nil {
+	middleware.RespondWithJSON(w, http.StatusOK, toKeyResponse(key))
+}
+
+// EnableKey handles re-enabling a disabled key for crypto operations
+func EnableKey(w http.ResponseWriter, r *http.Request) {
+	setKeyState(w, r, database.KeyStateEnabled)
+}
+
+// DisableKey handles disabling a key. Its metadata is kept but crypto operations are refused.
+func DisableKey(w http.ResponseWriter, r *http.Request) {
+	setKeyState(w, r, database.KeyStateDisabled)
+}
+
+// setKeyState switches the key in the request path to the given enabled/disabled state
+func setKeyState(w http.ResponseWriter, r *http.Request, state string) {
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.SetKeyState(key, state); err != nil {
 		if err == sql.ErrNoRows {
-			middleware.RespondWithError(w, http.StatusNotFound, "Key not found or not owned by user")
+			middleware.RespondWithError(w, http.StatusConflict, "Key is pending deletion; cancel the deletion first")
 			return
 		}
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete key")
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to update key state")
 		return
 	}
 
-	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
-}
\ No newline at end of file
+	middleware.RespondWithJSON(w, http.StatusOK, toKeyResponse(key))
+}
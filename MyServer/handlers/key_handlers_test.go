@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+)
+
+func TestUpdateKeyValidity(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                        string
+		body                        string
+		wantNotBefore, wantNotAfter *time.Time
+		wantErr                     bool
+	}{
+		{"omitted", `{"name": "renamed"}`, &notBefore, &notAfter, false},
+		{"cleared", `{"not_before": null, "not_after": null}`, nil, nil, false},
+		{"one cleared", `{"not_after": null}`, &notBefore, nil, false},
+		{"moved", `{"not_after": "2028-01-01T00:00:00Z"}`, &notBefore, &later, false},
+		{"invalid", `{"not_after": "next year"}`, &notBefore, &notAfter, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req KeyUpdateRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			key := &database.Key{NotBefore: &notBefore, NotAfter: &notAfter}
+
+			err := updateNullableTime(&key.NotBefore, req.NotBefore)
+			if err == nil {
+				err = updateNullableTime(&key.NotAfter, req.NotAfter)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateNullableTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !sameTime(key.NotBefore, tt.wantNotBefore) || !sameTime(key.NotAfter, tt.wantNotAfter) {
+				t.Errorf("validity = %v to %v, want %v to %v", key.NotBefore, key.NotAfter, tt.wantNotBefore, tt.wantNotAfter)
+			}
+		})
+	}
+}
+
+// sameTime reports whether two optional times are both unset or equal
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// Key wrap algorithms
+const (
+	KeyWrapAlgorithmKW  = "AES-KW"  // RFC 3394; key data must be a multiple of 8 bytes, at least 16
+	KeyWrapAlgorithmKWP = "AES-KWP" // RFC 5649; key data of any length
+)
+
+// maxWrapKeySize bounds the key data accepted for wrapping, in bytes
+const maxWrapKeySize = 8192
+
+// KeyWrapRequest defines the request body for wrapping key data with a stored key
+type KeyWrapRequest struct {
+	Algorithm string `json:"algorithm"` // "AES-KW" or "AES-KWP" (default)
+	KeyData   string `json:"key_data"`  // Base64 key to wrap
+}
+
+// KeyWrapResponse defines the response body for wrapped key data
+type KeyWrapResponse struct {
+	WrappedKey string `json:"wrapped_key"` // Base64
+	Algorithm  string `json:"algorithm"`
+	KeyVersion int    `json:"key_version"`
+}
+
+// KeyUnwrapRequest defines the request body for unwrapping key data with a stored key
+type KeyUnwrapRequest struct {
+	Algorithm  string `json:"algorithm"`   // "AES-KW" or "AES-KWP" (default)
+	WrappedKey string `json:"wrapped_key"` // Base64
+	// KeyVersion selects the key version the data was wrapped under; 0 means the current version
+	KeyVersion int `json:"key_version,omitempty"`
+}
+
+// KeyUnwrapResponse defines the response body for unwrapped key data
+type KeyUnwrapResponse struct {
+	KeyData string `json:"key_data"` // Base64
+}
+
+// validateKeyWrapAlgorithm defaults algorithm to AES-KWP and checks it is supported
+func validateKeyWrapAlgorithm(algorithm *string) string {
+	if *algorithm == "" {
+		*algorithm = KeyWrapAlgorithmKWP
+	}
+	if *algorithm != KeyWrapAlgorithmKW && *algorithm != KeyWrapAlgorithmKWP {
+		return "Invalid algorithm, expected AES-KW or AES-KWP"
+	}
+	return ""
+}
+
+// WrapKey handles wrapping key data with AES Key Wrap (RFC 3394) or AES Key Wrap with Padding (RFC 5649),
+// using one of the authenticated user's AES-256-GCM keys as the key encryption key. The wrapping key
+// never leaves the service; the wrapped key can be imported by HSMs and other systems holding the same KEK.
+func WrapKey(w http.ResponseWriter, r *http.Request) {
+	var req KeyWrapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if msg := validateKeyWrapAlgorithm(&req.Algorithm); msg != "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	keyData, err := base64.StdEncoding.DecodeString(req.KeyData)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 key_data")
+		return
+	}
+	if len(keyData) == 0 || len(keyData) > maxWrapKeySize {
+		middleware.RespondWithError(w, http.StatusBadRequest, "key_data must be between 1 and 8192 bytes")
+		return
+	}
+
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireKeyEnabled(w, key) || !requireKeyAlgorithm(w, key, utils.AlgorithmAES256GCM) ||
+		!requireKeyCanEncrypt(w, key, time.Now()) {
+		return
+	}
+
+	var wrapped []byte
+	if req.Algorithm == KeyWrapAlgorithmKW {
+		wrapped, err = utils.WrapKeyAES(key.KeyMaterial, keyData)
+	} else {
+		wrapped, err = utils.WrapKeyAESPad(key.KeyMaterial, keyData)
+	}
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Failed to wrap key: "+err.Error())
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, KeyWrapResponse{
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		Algorithm:  req.Algorithm,
+		KeyVersion: key.Version,
+	})
+}
+
+// UnwrapKey handles unwrapping key data wrapped with AES-KW or AES-KWP under one of the authenticated
+// user's AES-256-GCM keys, whether by WrapKey or by another system sharing the key encryption key
+func UnwrapKey(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req KeyUnwrapRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if msg := validateKeyWrapAlgorithm(&req.Algorithm); msg != "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, msg)
+			return
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(req.WrappedKey)
+		if err != nil || len(wrapped) == 0 {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid base64 wrapped_key")
+			return
+		}
+		if len(wrapped) > maxWrapKeySize+16 {
+			middleware.RespondWithError(w, http.StatusBadRequest, "wrapped_key is too long")
+			return
+		}
+
+		key, ok := getKeyFromPath(w, r)
+		if !ok {
+			return
+		}
+		if !requireKeyEnabled(w, key) || !requireKeyAlgorithm(w, key, utils.AlgorithmAES256GCM) ||
+			!requireKeyCanDecrypt(w, key, time.Now(), cfg.KeyDecryptionGraceDays) {
+			return
+		}
+		keyMaterial, ok := keyMaterialForVersion(w, key, req.KeyVersion)
+		if !ok {
+			return
+		}
+
+		var keyData []byte
+		if req.Algorithm == KeyWrapAlgorithmKW {
+			keyData, err = utils.UnwrapKeyAES(keyMaterial, wrapped)
+		} else {
+			keyData, err = utils.UnwrapKeyAESPad(keyMaterial, wrapped)
+		}
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Failed to unwrap key: "+err.Error())
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, KeyUnwrapResponse{
+			KeyData: base64.StdEncoding.EncodeToString(keyData),
+		})
+	}
+}
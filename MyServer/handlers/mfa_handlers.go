@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// MFA settings
+const (
+	// recoveryCodeCount is how many recovery codes are issued when TOTP is confirmed
+	recoveryCodeCount = 10
+	// maxMFAChallengeAttempts is how many codes may be tried against one MFA challenge before the
+	// user must log in with their password again
+	maxMFAChallengeAttempts = 5
+)
+
+// MFAChallengeResponse defines the login response for users with MFA enabled
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"` // Always true, to tell the response apart from a LoginResponse
+	MFAToken    string `json:"mfa_token"`    // Sent to /login/mfa with a code
+	ExpiresIn   int    `json:"expires_in"`   // MFA token lifetime, in seconds
+}
+
+// MFALoginRequest defines the request body for the second step of an MFA login.
+// Exactly one of Code and RecoveryCode must be set.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`          // Current code from the authenticator app
+	RecoveryCode string `json:"recovery_code"` // One of the recovery codes, if the authenticator is lost
+}
+
+// TOTPEnrollRequest defines the request body for starting TOTP enrollment
+type TOTPEnrollRequest struct {
+	Password string `json:"password"`
+}
+
+// TOTPEnrollResponse defines the response body for starting TOTP enrollment
+type TOTPEnrollResponse struct {
+	Secret          string `json:"secret"`           // Base32 secret, for typing into an authenticator app
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI, for rendering as a QR code
+}
+
+// TOTPConfirmRequest defines the request body for confirming TOTP enrollment
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPConfirmResponse defines the response body for confirming TOTP enrollment
+type TOTPConfirmResponse struct {
+	// RecoveryCodes each replace one TOTP code, once. They are shown only in this response.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TOTPDisableRequest defines the request body for disabling TOTP. Exactly one of Code and
+// RecoveryCode must be set.
+type TOTPDisableRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPStatusResponse describes the authenticated user's TOTP enrollment
+type TOTPStatusResponse struct {
+	Enabled                bool       `json:"enabled"` // Whether logins require a code
+	Pending                bool       `json:"pending"` // Whether an enrollment awaits confirmation
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// startMFAChallenge creates an MFA challenge for user, whose password has been checked, and writes it
+// as the login response
+func startMFAChallenge(cfg *config.Config, w http.ResponseWriter, user *database.User) {
+	token, tokenHash, err := utils.GenerateMFAChallengeToken()
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate MFA token")
+		return
+	}
+
+	challenge := &database.MFAChallenge{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(cfg.MFAChallengeTTL),
+	}
+	if err := database.CreateMFAChallenge(challenge); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to start MFA challenge")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(cfg.MFAChallengeTTL.Seconds()),
+	})
+}
+
+// checkMFACode checks a TOTP code or recovery code from user against their confirmed authenticator,
+// using it up so it can't be presented again. Exactly one of code and recoveryCode must be set.
+func checkMFACode(userID int, totp *database.UserTOTP, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := utils.ValidateTOTP(totp.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return database.UseTOTPStep(userID, step)
+	}
+	return database.UseRecoveryCode(userID, utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode)))
+}
+
+// LoginMFA handles the second step of a login by a user with MFA enabled: exchanging the MFA token
+// and a TOTP or recovery code for a session
+func LoginMFA(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.MFAToken == "" || (req.Code == "") == (req.RecoveryCode == "") {
+			middleware.RespondWithError(w, http.StatusBadRequest, "MFA token and either a code or a recovery code are required")
+			return
+		}
+
+		tokenHash := utils.HashToken(req.MFAToken)
+		challenge, err := database.AttemptMFAChallenge(tokenHash, maxMFAChallengeAttempts)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if challenge == nil {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token, log in again")
+			return
+		}
+
+		user, err := database.GetUserByID(challenge.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		totp, err := database.GetUserTOTP(challenge.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil || totp == nil || totp.ConfirmedAt == nil {
+			// The user was deleted or disabled MFA since logging in
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token, log in again")
+			return
+		}
+
+		// Codes count against the same limits as passwords, so they can't be guessed across many challenges
+		attempt := beginLoginAttempt(cfg, w, r, user.Username)
+		if attempt == nil {
+			return
+		}
+		ok, err := checkMFACode(user.ID, totp, req.Code, req.RecoveryCode)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+		attempt.succeeded()
+
+		if err := database.DeleteMFAChallenge(tokenHash); err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if req.RecoveryCode != "" {
+			log.Printf("User %q logged in with a recovery code", user.Username)
+		}
+
+		completeLogin(cfg, w, r, user)
+	}
+}
+
+// GetTOTPStatus handles reporting whether the authenticated user has TOTP enabled
+func GetTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	totp, err := database.GetUserTOTP(claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	resp := TOTPStatusResponse{}
+	if totp != nil {
+		resp.Enabled = totp.ConfirmedAt != nil
+		resp.Pending = totp.ConfirmedAt == nil
+		resp.ConfirmedAt = totp.ConfirmedAt
+	}
+	if resp.Enabled {
+		if resp.RecoveryCodesRemaining, err = database.CountUnusedRecoveryCodes(claims.UserID); err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// EnrollTOTP handles starting TOTP enrollment for the authenticated user, given their password.
+// It returns a new secret, which takes effect once confirmed with a code. Enrolling again before
+// confirming replaces the pending secret.
+func EnrollTOTP(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req TOTPEnrollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		user, err := database.GetUserByID(claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		attempt := beginLoginAttempt(cfg, w, r, user.Username)
+		if attempt == nil {
+			return
+		}
+		if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+		attempt.succeeded()
+
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate TOTP secret")
+			return
+		}
+		if err := database.SavePendingUserTOTP(user.ID, secret); err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusConflict, "TOTP is already enabled; disable it first")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to save TOTP secret")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusCreated, TOTPEnrollResponse{
+			Secret:          utils.EncodeTOTPSecret(secret),
+			ProvisioningURI: utils.TOTPProvisioningURI(cfg.TOTPIssuer, user.Username, secret),
+		})
+	}
+}
+
+// ConfirmTOTP handles confirming the authenticated user's pending TOTP enrollment with a code from
+// their authenticator app. From then on logins require a code, and the response holds the user's
+// recovery codes.
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	var req TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	totp, err := database.GetUserTOTP(claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if totp == nil || totp.ConfirmedAt != nil {
+		middleware.RespondWithError(w, http.StatusConflict, "No TOTP enrollment is pending")
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(totp.Secret, req.Code, time.Now())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	hashes := make([][]byte, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(code))
+	}
+
+	if err := database.ConfirmUserTOTP(claims.UserID, step, hashes); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusConflict, "No TOTP enrollment is pending")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to confirm TOTP")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, TOTPConfirmResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP handles removing the authenticated user's TOTP authenticator and recovery codes, given
+// their password and a current code or recovery code. A pending enrollment is cancelled with the
+// password alone.
+func DisableTOTP(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req TOTPDisableRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		user, err := database.GetUserByID(claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		attempt := beginLoginAttempt(cfg, w, r, user.Username)
+		if attempt == nil {
+			return
+		}
+		if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+		attempt.succeeded()
+
+		totp, err := database.GetUserTOTP(user.ID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if totp == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "TOTP is not enabled")
+			return
+		}
+		if totp.ConfirmedAt != nil {
+			if (req.Code == "") == (req.RecoveryCode == "") {
+				middleware.RespondWithError(w, http.StatusBadRequest, "Either a code or a recovery code is required")
+				return
+			}
+			attempt := beginLoginAttempt(cfg, w, r, user.Username)
+			if attempt == nil {
+				return
+			}
+			ok, err := checkMFACode(user.ID, totp, req.Code, req.RecoveryCode)
+			if err != nil {
+				middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if !ok {
+				middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid code")
+				return
+			}
+			attempt.succeeded()
+		}
+
+		if err := database.DeleteUserTOTP(user.ID); err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusNotFound, "TOTP is not enabled")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to disable TOTP")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// maxPasswordBytes is the longest password accepted. Argon2id takes any length, this just bounds request work.
+const maxPasswordBytes = 256
+
+// passwordCharacterClasses returns how many of lowercase letters, uppercase letters, digits and
+// other characters password contains
+func passwordCharacterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// checkPasswordPolicy checks a password for the given username against the configured password policy
+// and breached-password list. It returns why the password is rejected, or "" if it is acceptable.
+func checkPasswordPolicy(cfg *config.Config, password, username string) (string, error) {
+	if utf8.RuneCountInString(password) < cfg.PasswordMinLength {
+		return fmt.Sprintf("Password must be at least %d characters long", cfg.PasswordMinLength), nil
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("Password must be at most %d bytes long", maxPasswordBytes), nil
+	}
+	if passwordCharacterClasses(password) < cfg.PasswordMinCharacterClasses {
+		return fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and other characters",
+			cfg.PasswordMinCharacterClasses), nil
+	}
+	if cfg.PasswordDisallowUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return "Password must not contain the username", nil
+	}
+
+	if cfg.BreachedPasswordsDir != "" {
+		breached, err := utils.IsBreachedPassword(cfg.BreachedPasswordsDir, password)
+		if err != nil {
+			return "", err
+		}
+		if breached {
+			return "Password appears in a list of breached passwords, choose another", nil
+		}
+	}
+	return "", nil
+}
+
+// passwordMeetsPolicy reports whether a password that has just been used to log in still meets the
+// password policy. If the check itself fails, it logs the error and gives the password the benefit of
+// the doubt, so a broken breached-password list doesn't lock users out.
+func passwordMeetsPolicy(cfg *config.Config, password, username string) bool {
+	violation, err := checkPasswordPolicy(cfg, password, username)
+	if err != nil {
+		log.Printf("Error checking password policy at login of %q: %v", username, err)
+		return true
+	}
+	return violation == ""
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// Limits for random data requests
+const (
+	defaultRandomBytes = 32
+	maxRandomBytes     = 1024
+
+	defaultPasswordLength = 20
+	minPasswordLength     = 8
+	maxPasswordLength     = 256
+)
+
+// RandomResponse defines the response body for random bytes
+type RandomResponse struct {
+	Random   string `json:"random"`
+	Encoding string `json:"encoding"`
+}
+
+// PasswordResponse defines the response body for a generated password
+type PasswordResponse struct {
+	Password string `json:"password"`
+}
+
+// GetRandom handles generating random bytes from the server's CSPRNG.
+// Query parameters: bytes (1-1024, default 32) and encoding (base64 or hex, default base64).
+func GetRandom(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	n := defaultRandomBytes
+	if bytesStr := query.Get("bytes"); bytesStr != "" {
+		var err error
+		n, err = strconv.Atoi(bytesStr)
+		if err != nil || n < 1 || n > maxRandomBytes {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid bytes, expected 1 to "+strconv.Itoa(maxRandomBytes))
+			return
+		}
+	}
+
+	encoding := query.Get("encoding")
+	if encoding == "" {
+		encoding = "base64"
+	}
+	if encoding != "base64" && encoding != "hex" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid encoding, expected base64 or hex")
+		return
+	}
+
+	random, err := utils.GenerateRandomBytes(n)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate random bytes")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	resp := RandomResponse{Encoding: encoding}
+	if encoding == "hex" {
+		resp.Random = hex.EncodeToString(random)
+	} else {
+		resp.Random = base64.StdEncoding.EncodeToString(random)
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// GetRandomPassword handles generating a random password.
+// Query parameters: length (8-256, default 20), and lower, upper, digits and symbols (true or false,
+// default true) selecting the character classes. The password contains at least one character of
+// every selected class.
+func GetRandomPassword(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	length := defaultPasswordLength
+	if lengthStr := query.Get("length"); lengthStr != "" {
+		var err error
+		length, err = strconv.Atoi(lengthStr)
+		if err != nil || length < minPasswordLength || length > maxPasswordLength {
+			middleware.RespondWithError(w, http.StatusBadRequest,
+				"Invalid length, expected "+strconv.Itoa(minPasswordLength)+" to "+strconv.Itoa(maxPasswordLength))
+			return
+		}
+	}
+
+	classes := []string{}
+	for _, class := range []struct {
+		param   string
+		charset string
+	}{
+		{"lower", utils.PasswordLower},
+		{"upper", utils.PasswordUpper},
+		{"digits", utils.PasswordDigits},
+		{"symbols", utils.PasswordSymbols},
+	} {
+		enabled := true
+		if value := query.Get(class.param); value != "" {
+			var err error
+			enabled, err = strconv.ParseBool(value)
+			if err != nil {
+				middleware.RespondWithError(w, http.StatusBadRequest, "Invalid "+class.param+", expected true or false")
+				return
+			}
+		}
+		if enabled {
+			classes = append(classes, class.charset)
+		}
+	}
+	if len(classes) == 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "At least one character class must be enabled")
+		return
+	}
+
+	password, err := utils.GeneratePassword(length, classes)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate password")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	middleware.RespondWithJSON(w, http.StatusOK, PasswordResponse{Password: password})
+}
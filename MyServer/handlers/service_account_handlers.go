@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/gorilla/mux"
+)
+
+// ServiceAccountCreateRequest defines the request body for creating a service account
+type ServiceAccountCreateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// APIKeyCreateRequest defines the request body for creating an API key
+type APIKeyCreateRequest struct {
+	Scopes    []string   `json:"scopes"`               // At least one of "keys:read", "keys:write" and "crypto"
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Omit for a key that never expires
+}
+
+// APIKeyCreateResponse defines the response body for a newly created API key
+type APIKeyCreateResponse struct {
+	database.APIKey
+	// Key is the full API key. It is shown only in this response; store it securely.
+	Key string `json:"key"`
+}
+
+// getServiceAccountFromPath loads the authenticated user's service account named by the {id} path variable.
+// On failure it writes the error response and returns false.
+func getServiceAccountFromPath(w http.ResponseWriter, r *http.Request) (*database.ServiceAccount, bool) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid service account ID")
+		return nil, false
+	}
+
+	sa, err := database.GetServiceAccount(id, claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if sa == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "Service account not found or not owned by user")
+		return nil, false
+	}
+	return sa, true
+}
+
+// CreateServiceAccount handles creating a service account owned by the authenticated user
+func CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	var req ServiceAccountCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.Name == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Service account name is required")
+		return
+	}
+
+	existing, err := database.GetServiceAccountByName(req.Name, claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if existing != nil {
+		middleware.RespondWithError(w, http.StatusConflict, "Service account with this name already exists")
+		return
+	}
+
+	sa := &database.ServiceAccount{
+		OwnerID:     claims.UserID,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := database.CreateServiceAccount(sa); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create service account")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusCreated, sa)
+}
+
+// GetServiceAccounts handles listing the authenticated user's service accounts
+func GetServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	accounts, err := database.GetServiceAccounts(claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, accounts)
+}
+
+// DeleteServiceAccount handles deleting one of the authenticated user's service accounts.
+// Its API keys stop working immediately.
+func DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	sa, ok := getServiceAccountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteServiceAccount(sa.ID, sa.OwnerID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "Service account not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete service account")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// CreateAPIKey handles issuing a new API key for one of the authenticated user's service accounts
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	sa, ok := getServiceAccountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req APIKeyCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !database.IsValidScope(scope) {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid scope "+scope+", expected keys:read, keys:write or crypto")
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	key, prefix, secretHash, err := utils.GenerateAPIKey()
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+
+	apiKey := database.APIKey{
+		ServiceAccountID: sa.ID,
+		Prefix:           prefix,
+		SecretHash:       secretHash,
+		Scopes:           req.Scopes,
+		ExpiresAt:        req.ExpiresAt,
+	}
+	if err := database.CreateAPIKey(&apiKey); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusCreated, APIKeyCreateResponse{APIKey: apiKey, Key: key})
+}
+
+// GetAPIKeys handles listing the API keys of one of the authenticated user's service accounts,
+// with when and from where each was last used. The keys themselves are never shown again.
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	sa, ok := getServiceAccountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := database.GetAPIKeys(sa.ID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, keys)
+}
+
+// DeleteAPIKey handles revoking an API key of one of the authenticated user's service accounts
+func DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	sa, ok := getServiceAccountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(mux.Vars(r)["keyId"])
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := database.DeleteAPIKey(keyID, sa.ID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete API key")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
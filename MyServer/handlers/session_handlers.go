@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/gorilla/mux"
+)
+
+// maxUserAgentLength bounds the User-Agent recorded with a session, in bytes
+const maxUserAgentLength = 255
+
+// RefreshRequest defines the request body for refreshing a session
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SessionResponse describes one of the authenticated user's sessions
+type SessionResponse struct {
+	database.Session
+	Current bool `json:"current"` // Whether this is the session of the token the request was made with
+}
+
+// SessionsRevokedResponse defines the response body for revoking several sessions at once
+type SessionsRevokedResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// issueTokens generates an access token for user in the given session, and returns it with the
+// session's refresh token
+func issueTokens(cfg *config.Config, user *database.User, sessionID, refreshToken string) (*LoginResponse, error) {
+	claims := &utils.Claims{
+		UserID:       user.ID,
+		Username:     user.Username,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+	}
+	claims.ID = sessionID
+	claims.Issuer = cfg.SessionTokenIssuer
+
+	token, err := middleware.SignSessionToken(claims, cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:                  token,
+		RefreshToken:           refreshToken,
+		ExpiresIn:              int(cfg.AccessTokenTTL.Seconds()),
+		PasswordChangeRequired: user.PasswordChangeRequired,
+	}, nil
+}
+
+// startSession creates a new session for user, logged in through r, and issues its first token pair
+func startSession(cfg *config.Config, r *http.Request, user *database.User) (*LoginResponse, error) {
+	sessionID, err := utils.GenerateSessionID()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshTokenHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session := &database.Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: refreshTokenHash,
+		UserAgent:        userAgent,
+		RemoteAddr:       middleware.ClientIP(r),
+		ExpiresAt:        time.Now().Add(cfg.SessionTTL),
+	}
+	if err := database.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	return issueTokens(cfg, user, sessionID, refreshToken)
+}
+
+// Refresh handles exchanging a refresh token for a new access token and a new refresh token.
+// Each refresh token can be used once. Presenting one that has already been exchanged revokes
+// its session, since either the client or an attacker holds a stolen copy.
+func Refresh(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.RefreshToken == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Refresh token is required")
+			return
+		}
+
+		oldHash := utils.HashToken(req.RefreshToken)
+		refreshToken, refreshTokenHash, err := utils.GenerateRefreshToken()
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate refresh token")
+			return
+		}
+
+		session, err := database.RotateSessionRefreshToken(oldHash, refreshTokenHash, middleware.ClientIP(r))
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if session == nil {
+			sessionID, err := database.RevokeSessionByPreviousRefreshToken(oldHash)
+			if err != nil {
+				middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if sessionID != "" {
+				log.Printf("Refresh token reuse detected, revoked session %s", sessionID)
+			}
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+			return
+		}
+
+		// Roles and usernames are read afresh, so changes apply from the next refresh
+		user, err := database.GetUserByID(session.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+			return
+		}
+
+		resp, err := issueTokens(cfg, user, session.ID, refreshToken)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		middleware.RespondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// Logout handles revoking the session of the token the request was made with.
+// Its access token and refresh token stop working immediately.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	// A concurrent revocation of the same session is not an error here
+	if err := database.RevokeSession(claims.ID, claims.UserID); err != nil && err != sql.ErrNoRows {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// GetSessions handles listing the authenticated user's active sessions
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	sessions, err := database.GetActiveSessions(claims.UserID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	resp := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		resp[i] = SessionResponse{Session: session, Current: session.ID == claims.ID}
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// RevokeSession handles revoking one of the authenticated user's sessions, such as one left
+// logged in on a lost device. Revoking the current session is the same as logging out.
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	if err := database.RevokeSession(mux.Vars(r)["id"], claims.UserID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "Session not found or already revoked")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// RevokeOtherSessions handles revoking all of the authenticated user's sessions except the current one
+func RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	revoked, err := database.RevokeUserSessions(claims.UserID, claims.ID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, SessionsRevokedResponse{Revoked: revoked})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/scheduler"
+)
+
+// SessionKeyRotateRequest defines the request body for rotating the session signing key
+type SessionKeyRotateRequest struct {
+	// Immediate makes the new key sign at once and expires the existing keys, logging every access
+	// token out. Use it when a signing key may be compromised.
+	Immediate bool `json:"immediate"`
+}
+
+// GetSessionSigningKeys handles listing the unexpired session signing keys, without their private keys
+func GetSessionSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := database.GetSessionSigningKeys(time.Now())
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, keys)
+}
+
+// RotateSessionSigningKey handles replacing the session signing key ahead of its scheduled rotation.
+// Other server instances pick the new key up within a minute.
+func RotateSessionSigningKey(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The body is optional
+		var req SessionKeyRotateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		key, err := scheduler.RotateSessionSigningKey(r.Context(), cfg, req.Immediate)
+		if err != nil {
+			if err == scheduler.ErrSessionKeyRotationInProgress {
+				middleware.RespondWithError(w, http.StatusConflict, "A session signing key rotation is already in progress")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to rotate session signing key")
+			return
+		}
+		middleware.ReloadSessionKeys()
+
+		middleware.RespondWithJSON(w, http.StatusCreated, key)
+	}
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/utils"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHRoleRequest defines the request body for creating or replacing an SSH CA role
+type SSHRoleRequest struct {
+	CertType          string              `json:"cert_type"` // "user" (default) or "host"
+	AllowedPrincipals []string            `json:"allowed_principals"`
+	DefaultPrincipals []string            `json:"default_principals"`
+	CriticalOptions   database.SSHOptions `json:"critical_options"`
+	// Extensions defaults to the ssh-keygen defaults (permit-pty etc.) for user certificates
+	Extensions database.SSHOptions `json:"extensions"`
+	TTL        int                 `json:"ttl"`     // In seconds; required
+	MaxTTL     int                 `json:"max_ttl"` // In seconds; defaults to ttl
+}
+
+// SSHSignRequest defines the request body for signing an SSH public key
+type SSHSignRequest struct {
+	PublicKey  string   `json:"public_key"` // In authorized_keys format, e.g. "ssh-ed25519 AAAA... user@host"
+	Role       string   `json:"role"`
+	Principals []string `json:"principals,omitempty"` // Defaults to the role's default_principals
+	TTL        int      `json:"ttl,omitempty"`        // In seconds; defaults to the role's ttl
+}
+
+// SSHSignResponse defines the response body for a signed SSH certificate
+type SSHSignResponse struct {
+	SignedKey   string    `json:"signed_key"` // In authorized_keys format, to save as <key>-cert.pub
+	Serial      string    `json:"serial"`
+	KeyID       string    `json:"key_id"` // Certificate identity logged by sshd: "<username>:<role>:<serial>"
+	Principals  []string  `json:"principals"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// SSHCAPublicKeyResponse defines the response body for an SSH CA's public key
+type SSHCAPublicKeyResponse struct {
+	PublicKey string `json:"public_key"` // For sshd's TrustedUserCAKeys, or @cert-authority in known_hosts
+}
+
+// getSSHCAKeyFromPath loads the authenticated user's Ed25519 key identified by the {id} path variable.
+// On failure it writes the error response and returns false.
+func getSSHCAKeyFromPath(w http.ResponseWriter, r *http.Request) (*database.Key, bool) {
+	key, ok := getKeyFromPath(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !requireKeyAlgorithm(w, key, utils.AlgorithmEd25519) {
+		return nil, false
+	}
+	return key, true
+}
+
+// principalAllowed reports whether a principal matches one of a role's allowed glob patterns
+func principalAllowed(principal string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, principal); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSSHCAPublicKey handles retrieving the public key of one of the authenticated user's SSH CA keys.
+// Rotating the key changes this public key, so hosts trusting the CA must be updated afterwards.
+func GetSSHCAPublicKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := getSSHCAKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	signer, err := utils.SSHSigner(key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load SSH CA key")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, SSHCAPublicKeyResponse{
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+	})
+}
+
+// PutSSHRole handles creating or replacing an SSH CA role named by the {name} path variable
+func PutSSHRole(w http.ResponseWriter, r *http.Request) {
+	var req SSHRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.CertType == "" {
+		req.CertType = database.SSHCertTypeUser
+	}
+	if req.CertType != database.SSHCertTypeUser && req.CertType != database.SSHCertTypeHost {
+		middleware.RespondWithError(w, http.StatusBadRequest, "cert_type must be user or host")
+		return
+	}
+	if len(req.AllowedPrincipals) == 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "At least one allowed principal is required")
+		return
+	}
+	for _, pattern := range req.AllowedPrincipals {
+		if _, err := path.Match(pattern, ""); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid principal pattern: "+pattern)
+			return
+		}
+	}
+	for _, principal := range req.DefaultPrincipals {
+		if !principalAllowed(principal, req.AllowedPrincipals) {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Default principal "+principal+" is not in allowed_principals")
+			return
+		}
+	}
+	if req.CertType == database.SSHCertTypeHost && len(req.CriticalOptions) > 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Host certificates cannot carry critical options")
+		return
+	}
+	for option := range req.CriticalOptions {
+		if !utils.SSHCriticalOptions[option] {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Unknown critical option: "+option)
+			return
+		}
+	}
+	if req.Extensions == nil && req.CertType == database.SSHCertTypeUser {
+		req.Extensions = database.SSHOptions(utils.SSHDefaultUserExtensions)
+	}
+	if req.TTL <= 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "ttl must be positive")
+		return
+	}
+	if req.MaxTTL == 0 {
+		req.MaxTTL = req.TTL
+	}
+	if req.MaxTTL < req.TTL {
+		middleware.RespondWithError(w, http.StatusBadRequest, "max_ttl cannot be less than ttl")
+		return
+	}
+
+	key, ok := getSSHCAKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	role := &database.SSHRole{
+		KeyID:             key.ID,
+		Name:              mux.Vars(r)["name"],
+		CertType:          req.CertType,
+		AllowedPrincipals: req.AllowedPrincipals,
+		DefaultPrincipals: req.DefaultPrincipals,
+		CriticalOptions:   req.CriticalOptions,
+		Extensions:        req.Extensions,
+		TTL:               req.TTL,
+		MaxTTL:            req.MaxTTL,
+	}
+	if err := database.UpsertSSHRole(role); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to save SSH role")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, role)
+}
+
+// GetSSHRoles handles listing the roles of one of the authenticated user's SSH CA keys
+func GetSSHRoles(w http.ResponseWriter, r *http.Request) {
+	key, ok := getSSHCAKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	roles, err := database.GetSSHRoles(key.ID)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to retrieve SSH roles")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, roles)
+}
+
+// DeleteSSHRole handles deleting an SSH CA role
+func DeleteSSHRole(w http.ResponseWriter, r *http.Request) {
+	key, ok := getSSHCAKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := database.DeleteSSHRole(key.ID, mux.Vars(r)["name"]); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "SSH role not found")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete SSH role")
+		return
+	}
+	middleware.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "SSH role deleted successfully"})
+}
+
+// SignSSHKey handles signing an SSH user or host public key into an OpenSSH certificate with one of the
+// authenticated user's Ed25519 keys. The role decides the certificate type, allowed principals,
+// critical options, extensions and lifetime.
+func SignSSHKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+		return
+	}
+
+	var req SSHSignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.PublicKey == "" || req.Role == "" {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Public key and role are required")
+		return
+	}
+	if req.TTL < 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "ttl cannot be negative")
+		return
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid SSH public key")
+		return
+	}
+	if _, isCert := publicKey.(*ssh.Certificate); isCert {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Public key must be a plain key, not a certificate")
+		return
+	}
+
+	key, ok := getSSHCAKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if !requireKeyEnabled(w, key) || !requireKeyCanEncrypt(w, key, now) {
+		return
+	}
+
+	role, err := database.GetSSHRole(key.ID, req.Role)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if role == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "SSH role not found")
+		return
+	}
+
+	principals := req.Principals
+	if len(principals) == 0 {
+		principals = role.DefaultPrincipals
+	}
+	// A certificate without principals is valid for every principal, so never issue one
+	if len(principals) == 0 {
+		middleware.RespondWithError(w, http.StatusBadRequest, "At least one principal is required")
+		return
+	}
+	for _, principal := range principals {
+		if !principalAllowed(principal, role.AllowedPrincipals) {
+			middleware.RespondWithError(w, http.StatusForbidden, "Principal "+principal+" is not allowed by role "+role.Name)
+			return
+		}
+	}
+
+	ttl := role.TTL
+	if req.TTL != 0 {
+		ttl = req.TTL
+	}
+	if ttl > role.MaxTTL {
+		middleware.RespondWithError(w, http.StatusBadRequest, "ttl exceeds the role's max_ttl of "+strconv.Itoa(role.MaxTTL)+" seconds")
+		return
+	}
+
+	certType := uint32(ssh.UserCert)
+	if role.CertType == database.SSHCertTypeHost {
+		certType = ssh.HostCert
+	}
+	signer, err := utils.SSHSigner(key.KeyMaterial)
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to load SSH CA key")
+		return
+	}
+	validBefore := now.Add(time.Duration(ttl) * time.Second)
+	cert, err := utils.SignSSHCertificate(signer, utils.SSHCertificateRequest{
+		PublicKey:       publicKey,
+		CertType:        certType,
+		KeyID:           claims.Username + ":" + role.Name, // Never the caller's choice, so logs can't be spoofed
+		Principals:      principals,
+		ValidBefore:     validBefore,
+		CriticalOptions: role.CriticalOptions,
+		Extensions:      role.Extensions,
+	})
+	if err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to sign SSH certificate")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusOK, SSHSignResponse{
+		SignedKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		Serial:      strconv.FormatUint(cert.Serial, 10),
+		KeyID:       cert.KeyId,
+		Principals:  principals,
+		ValidBefore: validBefore.Truncate(time.Second),
+	})
+}
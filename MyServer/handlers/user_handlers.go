@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strconv"
 
+	"github.com/anurag/magicgate/MyServer/config"
 	"github.com/anurag/magicgate/MyServer/database"
 	"github.com/anurag/magicgate/MyServer/middleware"
 	"github.com/anurag/magicgate/MyServer/utils"
@@ -14,8 +16,9 @@ import (
 
 // UserCreateRequest defines the request body for creating a user
 type UserCreateRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	BootstrapToken string `json:"bootstrap_token,omitempty"` // BOOTSTRAP_ADMIN_TOKEN, required to register BOOTSTRAP_ADMIN_USERNAME
 }
 
 // UserUpdateRequest defines the request body for updating a user
@@ -23,47 +26,92 @@ type UserUpdateRequest struct {
 	Username string `json:"username"`
 }
 
-// CreateUser handles the creation of a new user
-func CreateUser(w http.ResponseWriter, r *http.Request) {
-	var req UserCreateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
+// UserRoleRequest defines the request body for changing a user's role
+type UserRoleRequest struct {
+	Role string `json:"role"` // "user", "operator", "auditor" or "admin"
+}
 
-	if req.Username == "" || req.Password == "" {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Username and password are required")
-		return
-	}
+// CreateUser handles the creation of a new user. The password must meet the password policy.
+func CreateUser(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UserCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
 
-	// Check if user already exists
-	existingUser, err := database.GetUserByUsername(req.Username)
-	if err != nil {
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error checking user existence")
-		return
+		if req.Username == "" || req.Password == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Username and password are required")
+			return
+		}
+
+		// The bootstrap admin's name is reserved for whoever holds the bootstrap token
+		if isBootstrapAdminUsername(cfg, req.Username) &&
+			(cfg.BootstrapAdminToken == "" || subtle.ConstantTimeCompare([]byte(req.BootstrapToken), []byte(cfg.BootstrapAdminToken)) != 1) {
+			middleware.RespondWithError(w, http.StatusForbidden, "This username is reserved")
+			return
+		}
+
+		violation, err := checkPasswordPolicy(cfg, req.Password, req.Username)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to check password")
+			return
+		}
+		if violation != "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, violation)
+			return
+		}
+
+		// Check if user already exists
+		existingUser, err := database.GetUserByUsername(req.Username)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error checking user existence")
+			return
+		}
+		if existingUser != nil {
+			middleware.RespondWithError(w, http.StatusConflict, "User with this username already exists")
+			return
+		}
+
+		hashedPassword, err := utils.HashPassword(req.Password)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		user := &database.User{
+			Username:     req.Username,
+			PasswordHash: hashedPassword,
+		}
+
+		if err := database.CreateUser(user); err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusCreated, user)
 	}
-	if existingUser != nil {
-		middleware.RespondWithError(w, http.StatusConflict, "User with this username already exists")
+}
+
+// GetMe handles retrieving the authenticated user's own account
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
 		return
 	}
 
-	hashedPassword, err := utils.HashPassword(req.Password)
+	user, err := database.GetUserByID(claims.UserID)
 	if err != nil {
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
-
-	user := &database.User{
-		Username:     req.Username,
-		PasswordHash: hashedPassword,
-	}
-
-	if err := database.CreateUser(user); err != nil {
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
+	if user == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	middleware.RespondWithJSON(w, http.StatusCreated, user)
+	middleware.RespondWithJSON(w, http.StatusOK, user)
 }
 
 // GetUser handles retrieving a user by ID
@@ -98,8 +146,58 @@ func GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	middleware.RespondWithJSON(w, http.StatusOK, users)
 }
 
-// UpdateUser handles updating a user's information
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
+// UpdateUser handles updating a user's information. Users can't be renamed to the bootstrap admin's name.
+func UpdateUser(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		var req UserUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Username == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Username is required for update")
+			return
+		}
+
+		user, err := database.GetUserByID(id)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		if isBootstrapAdminUsername(cfg, req.Username) && user.Username != req.Username {
+			middleware.RespondWithError(w, http.StatusForbidden, "This username is reserved")
+			return
+		}
+
+		user.Username = req.Username
+		if err := database.UpdateUser(user); err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusNotFound, "User not found for update")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, user)
+	}
+}
+
+// DeleteUser handles deleting a user by ID. The last admin can't be deleted.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -107,58 +205,305 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req UserUpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	err = database.DeleteUser(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err == database.ErrLastAdmin {
+			middleware.RespondWithError(w, http.StatusConflict, "Cannot delete the last admin")
+			return
+		}
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete user")
 		return
 	}
 
-	if req.Username == "" {
-		middleware.RespondWithError(w, http.StatusBadRequest, "Username is required for update")
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// UpdateUserRole handles changing a user's role. Admins cannot change their own role, so the
+// last admin can't lock everyone out. The new role applies to the user's next request.
+func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+	if !ok {
+		middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
 		return
 	}
 
-	user, err := database.GetUserByID(id)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
-	if user == nil {
-		middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+
+	var req UserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	user.Username = req.Username
-	if err := database.UpdateUser(user); err != nil {
+	if !database.IsValidRole(req.Role) {
+		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid role, expected user, operator, auditor or admin")
+		return
+	}
+	if id == claims.UserID {
+		middleware.RespondWithError(w, http.StatusForbidden, "Admins cannot change their own role")
+		return
+	}
+
+	if err := database.UpdateUserRole(id, req.Role); err != nil {
 		if err == sql.ErrNoRows {
-			middleware.RespondWithError(w, http.StatusNotFound, "User not found for update")
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
 			return
 		}
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to update user role")
 		return
 	}
 
+	user, err := database.GetUserByID(id)
+	if err != nil || user == nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
 	middleware.RespondWithJSON(w, http.StatusOK, user)
 }
 
-// DeleteUser handles deleting a user by ID
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+// UnlockUser handles lifting a login lockout of a user, forgetting the failed logins for their username.
+// Lockouts of client addresses are not affected and expire on their own.
+func UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		middleware.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	err = database.DeleteUser(id)
+	user, err := database.GetUserByID(id)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if user == nil {
+		middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := database.ClearLoginFailures(usernameThrottleKey(user.Username)); err != nil {
+		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// PasswordChangeRequest defines the request body for changing the authenticated user's password
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// AccountDeleteRequest defines the request body for deleting the authenticated user's account
+type AccountDeleteRequest struct {
+	Password string `json:"password"` // The current password, to confirm the deletion
+}
+
+// isBootstrapAdminUsername reports whether username is the configured bootstrap admin's
+func isBootstrapAdminUsername(cfg *config.Config, username string) bool {
+	return cfg.BootstrapAdminUsername != "" && username == cfg.BootstrapAdminUsername
+}
+
+// UpdateMe handles renaming the authenticated user's own account. Users can't rename themselves to
+// the bootstrap admin's name.
+func UpdateMe(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req UserUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Username == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Username is required for update")
+			return
+		}
+
+		existingUser, err := database.GetUserByUsername(req.Username)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error checking user existence")
+			return
+		}
+		if existingUser != nil && existingUser.ID != claims.UserID {
+			middleware.RespondWithError(w, http.StatusConflict, "User with this username already exists")
+			return
+		}
+
+		user, err := database.GetUserByID(claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
 			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
 			return
 		}
-		middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete user")
-		return
+
+		if isBootstrapAdminUsername(cfg, req.Username) && user.Username != req.Username {
+			middleware.RespondWithError(w, http.StatusForbidden, "This username is reserved")
+			return
+		}
+
+		user.Username = req.Username
+		if err := database.UpdateUser(user); err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusNotFound, "User not found for update")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, user)
 	}
+}
 
-	middleware.RespondWithJSON(w, http.StatusNoContent, nil)
-}
\ No newline at end of file
+// DeleteMe handles deleting the authenticated user's own account, together with its keys.
+// The current password is required so a stolen token alone cannot destroy the account. The last admin
+// can't delete their account.
+func DeleteMe(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req AccountDeleteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		user, err := database.GetUserByID(claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		attempt := beginLoginAttempt(cfg, w, r, user.Username)
+		if attempt == nil {
+			return
+		}
+		if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+		attempt.succeeded()
+
+		if err := database.DeleteUser(user.ID); err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+				return
+			}
+			if err == database.ErrLastAdmin {
+				middleware.RespondWithError(w, http.StatusConflict, "Cannot delete the last admin")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to delete user")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// ChangePassword handles changing the authenticated user's password. Every session and token issued
+// before the change is revoked, including the caller's; a new session is started in its place.
+func ChangePassword(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.GetUserClaimsFromContext(r.Context())
+		if !ok {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+
+		var req PasswordChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.CurrentPassword == "" || req.NewPassword == "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, "Current and new password are required")
+			return
+		}
+
+		user, err := database.GetUserByID(claims.UserID)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		attempt := beginLoginAttempt(cfg, w, r, user.Username)
+		if attempt == nil {
+			return
+		}
+		if !utils.CheckPasswordHash(req.CurrentPassword, user.PasswordHash) {
+			middleware.RespondWithError(w, http.StatusUnauthorized, "Invalid current password")
+			return
+		}
+		attempt.succeeded()
+
+		violation, err := checkPasswordPolicy(cfg, req.NewPassword, user.Username)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to check password")
+			return
+		}
+		if violation != "" {
+			middleware.RespondWithError(w, http.StatusBadRequest, violation)
+			return
+		}
+
+		hashedPassword, err := utils.HashPassword(req.NewPassword)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		tokenVersion, err := database.UpdateUserPassword(user.ID, hashedPassword)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				middleware.RespondWithError(w, http.StatusNotFound, "User not found")
+				return
+			}
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to update password")
+			return
+		}
+
+		if _, err := database.RevokeUserSessions(user.ID, ""); err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+
+		user.TokenVersion = tokenVersion
+		user.PasswordChangeRequired = false
+		resp, err := startSession(cfg, r, user)
+		if err != nil {
+			middleware.RespondWithError(w, http.StatusInternalServerError, "Failed to start session")
+			return
+		}
+
+		middleware.RespondWithJSON(w, http.StatusOK, resp)
+	}
+}
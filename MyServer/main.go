@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,46 +10,178 @@ import (
 	"github.com/anurag/magicgate/MyServer/database"
 	"github.com/anurag/magicgate/MyServer/handlers"
 	"github.com/anurag/magicgate/MyServer/middleware"
+	"github.com/anurag/magicgate/MyServer/scheduler"
+	"github.com/anurag/magicgate/MyServer/utils"
 	"github.com/gorilla/mux"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
+	utils.ConfigurePasswordHashing(utils.Argon2idParams{
+		Memory:      uint32(cfg.PasswordHashMemoryKiB),
+		Iterations:  uint32(cfg.PasswordHashIterations),
+		Parallelism: uint8(cfg.PasswordHashParallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}, []byte(cfg.PasswordPepper))
 
 	// Initialize database
 	database.InitDB(cfg.DatabaseURL)
 	defer database.CloseDB()
 
+	// Start background jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler.StartKeyRotation(ctx, cfg)
+	scheduler.StartKeyDeletion(ctx, cfg)
+	scheduler.StartSessionKeyRotation(ctx, cfg)
+
 	// Setup router
 	r := mux.NewRouter()
 
 	// Public routes
-	r.HandleFunc("/register", handlers.CreateUser).Methods("POST")
+	r.HandleFunc("/register", handlers.CreateUser(cfg)).Methods("POST")
 	r.HandleFunc("/login", handlers.Login(cfg)).Methods("POST")
+	r.HandleFunc("/login/mfa", handlers.LoginMFA(cfg)).Methods("POST")
+	r.HandleFunc("/refresh", handlers.Refresh(cfg)).Methods("POST")
+	r.Handle("/logout", middleware.AuthMiddleware(cfg, http.HandlerFunc(handlers.Logout))).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", handlers.GetJWKS(cfg)).Methods("GET")
+	r.HandleFunc("/.well-known/session-jwks.json", handlers.GetSessionJWKS).Methods("GET")
+	r.HandleFunc("/sdk/config", handlers.GetSDKConfig(cfg)).Methods("GET") // Authenticated by an app registration token
 
 	// Authenticated routes
 	authRouter := r.PathPrefix("/api").Subrouter()
-	authRouter.Use(middleware.AuthMiddleware(cfg))
+	authRouter.Use(func(next http.Handler) http.Handler { return middleware.AuthMiddleware(cfg, next) })
+
+	// Crypto operations are rate-limited per user and recorded in the audit log
+	cryptoLimiter := middleware.NewRateLimiter(float64(cfg.CryptoRateLimit), cfg.CryptoRateBurst)
+	crypto := func(action string, handler http.Handler) http.Handler {
+		return middleware.RequireScope(cryptoLimiter.Limit(middleware.Audit(action, handler)), database.ScopeCrypto)
+	}
+
+	// Key management routes. API keys need the matching scope; session callers are unrestricted.
+	keysRead := func(handler http.HandlerFunc) http.Handler {
+		return middleware.RequireScope(handler, database.ScopeKeysRead)
+	}
+	keysWrite := func(handler http.HandlerFunc) http.Handler {
+		return middleware.RequireScope(handler, database.ScopeKeysWrite)
+	}
+
+	// CRLs are public so relying parties can fetch them, but may need signing, so they're rate-limited too
+	r.Handle("/ca/{id}/crl", cryptoLimiter.Limit(handlers.GetCRL(cfg))).Methods("GET")
+
+	// Owner key sets are public too; the limit slows down probing for usernames
+	r.Handle("/jwks/{owner}", cryptoLimiter.Limit(handlers.GetOwnerJWKS(cfg))).Methods("GET")
+
+	// Role-gated routes. The role is read from the caller's user row, so role changes apply at once.
+	admins := func(action string, handler http.HandlerFunc) http.Handler {
+		return middleware.RequireRole(middleware.Audit(action, handler), database.RoleAdmin)
+	}
+	userReaders := func(handler http.HandlerFunc) http.Handler {
+		return middleware.RequireRole(handler, database.RoleAdmin, database.RoleOperator)
+	}
+	auditReaders := func(handler http.HandlerFunc) http.Handler {
+		return middleware.RequireRole(handler, database.RoleAdmin, database.RoleAuditor)
+	}
+
+	// The authenticated user's own account, for every role. Account, session and service account
+	// management is for people, so these routes reject API keys.
+	authRouter.Handle("/me", middleware.RequireSession(http.HandlerFunc(handlers.GetMe))).Methods("GET")
+	authRouter.Handle("/me", middleware.RequireSession(handlers.UpdateMe(cfg))).Methods("PATCH")
+	authRouter.Handle("/me", middleware.RequireSession(middleware.Audit("delete_account", handlers.DeleteMe(cfg)))).Methods("DELETE")
+	authRouter.Handle("/me/password", middleware.RequireSession(middleware.Audit("change_password", handlers.ChangePassword(cfg)))).Methods("POST")
+	authRouter.Handle("/me/mfa/totp", middleware.RequireSession(http.HandlerFunc(handlers.GetTOTPStatus))).Methods("GET")
+	authRouter.Handle("/me/mfa/totp", middleware.RequireSession(handlers.EnrollTOTP(cfg))).Methods("POST")
+	authRouter.Handle("/me/mfa/totp/confirm", middleware.RequireSession(middleware.Audit("enable_mfa", http.HandlerFunc(handlers.ConfirmTOTP)))).Methods("POST")
+	authRouter.Handle("/me/mfa/totp", middleware.RequireSession(middleware.Audit("disable_mfa", handlers.DisableTOTP(cfg)))).Methods("DELETE")
+
+	// The authenticated user's sessions
+	authRouter.Handle("/sessions", middleware.RequireSession(http.HandlerFunc(handlers.GetSessions))).Methods("GET")
+	authRouter.Handle("/sessions", middleware.RequireSession(http.HandlerFunc(handlers.RevokeOtherSessions))).Methods("DELETE")
+	authRouter.Handle("/sessions/{id}", middleware.RequireSession(http.HandlerFunc(handlers.RevokeSession))).Methods("DELETE")
 
-	// User CRUD (authenticated, but for simplicity, any authenticated user can access any user ID for now)
-	// A more secure implementation would check if the ID in the path matches the authenticated user's ID
-	// or if the user has an 'admin' role.
-	authRouter.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")
-	authRouter.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")
-	authRouter.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
-	authRouter.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	// The authenticated user's service accounts and their API keys
+	authRouter.Handle("/service-accounts", middleware.RequireSession(http.HandlerFunc(handlers.CreateServiceAccount))).Methods("POST")
+	authRouter.Handle("/service-accounts", middleware.RequireSession(http.HandlerFunc(handlers.GetServiceAccounts))).Methods("GET")
+	authRouter.Handle("/service-accounts/{id}", middleware.RequireSession(middleware.Audit("delete_service_account", http.HandlerFunc(handlers.DeleteServiceAccount)))).Methods("DELETE")
+	authRouter.Handle("/service-accounts/{id}/api-keys", middleware.RequireSession(middleware.Audit("create_api_key", http.HandlerFunc(handlers.CreateAPIKey)))).Methods("POST")
+	authRouter.Handle("/service-accounts/{id}/api-keys", middleware.RequireSession(http.HandlerFunc(handlers.GetAPIKeys))).Methods("GET")
+	authRouter.Handle("/service-accounts/{id}/api-keys/{keyId}", middleware.RequireSession(middleware.Audit("delete_api_key", http.HandlerFunc(handlers.DeleteAPIKey)))).Methods("DELETE")
+
+	// User management: admins manage users, operators may look them up
+	authRouter.Handle("/users", userReaders(handlers.GetAllUsers)).Methods("GET")
+	authRouter.Handle("/users/{id}", userReaders(handlers.GetUser)).Methods("GET")
+	authRouter.Handle("/users/{id}", admins("update_user", handlers.UpdateUser(cfg))).Methods("PUT")
+	authRouter.Handle("/users/{id}", admins("delete_user", handlers.DeleteUser)).Methods("DELETE")
+	authRouter.Handle("/users/{id}/role", admins("update_user_role", handlers.UpdateUserRole)).Methods("PUT")
+	authRouter.Handle("/users/{id}/unlock", admins("unlock_user", handlers.UnlockUser)).Methods("POST")
+
+	// Signing keys of the access tokens issued at login
+	authRouter.Handle("/admin/session-keys", middleware.RequireRole(http.HandlerFunc(handlers.GetSessionSigningKeys), database.RoleAdmin)).Methods("GET")
+	authRouter.Handle("/admin/session-keys/rotate", admins("rotate_session_key", handlers.RotateSessionSigningKey(cfg))).Methods("POST")
+
+	// Apps registered to use the SDKs, and the registration tokens their clients pass to Init
+	authRouter.Handle("/admin/apps", admins("create_app", handlers.CreateApp)).Methods("POST")
+	authRouter.Handle("/admin/apps", middleware.RequireRole(http.HandlerFunc(handlers.GetApps), database.RoleAdmin)).Methods("GET")
+	authRouter.Handle("/admin/apps/{id}", admins("delete_app", handlers.DeleteApp)).Methods("DELETE")
+	authRouter.Handle("/admin/apps/{id}/registration-tokens", admins("create_registration_token", handlers.CreateAppRegistrationToken)).Methods("POST")
+	authRouter.Handle("/admin/apps/{id}/registration-tokens", middleware.RequireRole(http.HandlerFunc(handlers.GetAppRegistrationTokens), database.RoleAdmin)).Methods("GET")
+	authRouter.Handle("/admin/apps/{id}/registration-tokens/{tokenId}", admins("delete_registration_token", handlers.DeleteAppRegistrationToken)).Methods("DELETE")
+
+	// Audit log, across all users
+	authRouter.Handle("/audit-logs", auditReaders(handlers.GetAuditLogs)).Methods("GET")
 
 	// Key CRUD (authenticated and user-specific)
-	authRouter.HandleFunc("/keys", handlers.CreateKey).Methods("POST")
-	authRouter.HandleFunc("/keys", handlers.GetAllKeys).Methods("GET")
-	authRouter.HandleFunc("/keys/{id}", handlers.GetKey).Methods("GET")
-	authRouter.HandleFunc("/keys/{id}", handlers.UpdateKey).Methods("PUT")
-	authRouter.HandleFunc("/keys/{id}", handlers.DeleteKey).Methods("DELETE")
+	authRouter.Handle("/keys", keysWrite(handlers.CreateKey)).Methods("POST")
+	authRouter.Handle("/keys", keysRead(handlers.GetAllKeys)).Methods("GET")
+	authRouter.Handle("/keys/{id}", keysRead(handlers.GetKey)).Methods("GET")
+	authRouter.Handle("/keys/{id}", keysWrite(handlers.UpdateKey)).Methods("PUT")
+	authRouter.Handle("/keys/{id}", keysWrite(handlers.DeleteKey(cfg))).Methods("DELETE")
+	authRouter.Handle("/keys/{id}/rotate", crypto("rotate_key", http.HandlerFunc(handlers.RotateKey))).Methods("POST")
+	authRouter.Handle("/keys/{id}/enable", keysWrite(handlers.EnableKey)).Methods("POST")
+	authRouter.Handle("/keys/{id}/disable", keysWrite(handlers.DisableKey)).Methods("POST")
+	authRouter.Handle("/keys/{id}/cancel-deletion", keysWrite(handlers.CancelKeyDeletion)).Methods("POST")
+	authRouter.Handle("/keys/{id}/derive", crypto("derive_key", handlers.DeriveKey(cfg))).Methods("POST")
+	authRouter.Handle("/keys/{id}/jwt/sign", crypto("jwt_sign", http.HandlerFunc(handlers.SignJWT))).Methods("POST")
+	authRouter.Handle("/keys/{id}/public-key", keysRead(handlers.GetPublicKey)).Methods("GET")
+	authRouter.Handle("/keys/{id}/wrap", crypto("wrap_key", http.HandlerFunc(handlers.WrapKey))).Methods("POST")
+	authRouter.Handle("/keys/{id}/unwrap", crypto("unwrap_key", handlers.UnwrapKey(cfg))).Methods("POST")
+
+	// Certificate authority routes ({id} is the CA's key ID)
+	authRouter.Handle("/ca/{id}", keysWrite(handlers.CreateCA)).Methods("POST")
+	authRouter.Handle("/ca/{id}", keysRead(handlers.GetCA)).Methods("GET")
+	authRouter.Handle("/ca/{id}/templates", keysRead(handlers.GetCertificateTemplates)).Methods("GET")
+	authRouter.Handle("/ca/{id}/templates/{name}", keysWrite(handlers.PutCertificateTemplate)).Methods("PUT")
+	authRouter.Handle("/ca/{id}/templates/{name}", keysWrite(handlers.DeleteCertificateTemplate)).Methods("DELETE")
+	authRouter.Handle("/ca/{id}/sign-csr", crypto("ca_sign_csr", http.HandlerFunc(handlers.SignCSR))).Methods("POST")
+	authRouter.Handle("/ca/{id}/certificates", keysRead(handlers.GetCertificates)).Methods("GET")
+	authRouter.Handle("/ca/{id}/certificates/{serial}/revoke", crypto("ca_revoke", http.HandlerFunc(handlers.RevokeCertificate))).Methods("POST")
+
+	// SSH certificate authority routes ({id} is the Ed25519 CA key's ID)
+	authRouter.Handle("/ssh/{id}/public-key", keysRead(handlers.GetSSHCAPublicKey)).Methods("GET")
+	authRouter.Handle("/ssh/{id}/roles", keysRead(handlers.GetSSHRoles)).Methods("GET")
+	authRouter.Handle("/ssh/{id}/roles/{name}", keysWrite(handlers.PutSSHRole)).Methods("PUT")
+	authRouter.Handle("/ssh/{id}/roles/{name}", keysWrite(handlers.DeleteSSHRole)).Methods("DELETE")
+	authRouter.Handle("/ssh/{id}/sign", crypto("ssh_sign", http.HandlerFunc(handlers.SignSSHKey))).Methods("POST")
+
+	// Key aliases (authenticated and user-specific), addressed without the "alias/" prefix
+	authRouter.Handle("/aliases", keysRead(handlers.GetAllAliases)).Methods("GET")
+	authRouter.Handle("/aliases/{name}", keysRead(handlers.GetAlias)).Methods("GET")
+	authRouter.Handle("/aliases/{name}", keysWrite(handlers.PutAlias)).Methods("PUT")
+	authRouter.Handle("/aliases/{name}", keysWrite(handlers.DeleteAlias)).Methods("DELETE")
 
 	// Crypto operations (authenticated and user-specific)
-	authRouter.HandleFunc("/encrypt", handlers.Encrypt(cfg)).Methods("POST")
-	authRouter.HandleFunc("/decrypt", handlers.Decrypt(cfg)).Methods("POST")
+	authRouter.Handle("/encrypt", crypto("encrypt", http.HandlerFunc(handlers.EncryptData))).Methods("POST")
+	authRouter.Handle("/decrypt", crypto("decrypt", handlers.DecryptData(cfg))).Methods("POST")
+	authRouter.Handle("/jwt/verify", crypto("jwt_verify", handlers.VerifyJWT(cfg))).Methods("POST")
+	authRouter.Handle("/jwe/encrypt", crypto("jwe_encrypt", http.HandlerFunc(handlers.EncryptJWE))).Methods("POST")
+	authRouter.Handle("/jwe/decrypt", crypto("jwe_decrypt", handlers.DecryptJWE(cfg))).Methods("POST")
+	authRouter.Handle("/hpke/open", crypto("hpke_open", handlers.OpenHPKE(cfg))).Methods("POST")
+	authRouter.Handle("/kem/encapsulate", crypto("kem_encapsulate", http.HandlerFunc(handlers.EncapsulateKey))).Methods("POST")
+	authRouter.Handle("/kem/decapsulate", crypto("kem_decapsulate", handlers.DecapsulateKey(cfg))).Methods("POST")
+	authRouter.Handle("/random", crypto("random", http.HandlerFunc(handlers.GetRandom))).Methods("GET")
+	authRouter.Handle("/random/password", crypto("random_password", http.HandlerFunc(handlers.GetRandomPassword))).Methods("GET")
 
 	// Start server
 	addr := fmt.Sprintf(":%s", cfg.ServerPort)
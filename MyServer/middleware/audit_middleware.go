@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/anurag/magicgate/MyServer/database"
+)
+
+// statusRecorder wraps an http.ResponseWriter to capture the response status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before passing it on
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Audit wraps next so that every call is recorded in the audit log under the given action,
+// together with the caller, request path and response status.
+// It must run after AuthMiddleware so the caller's claims are available.
+func Audit(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		event := &database.AuditEvent{
+			Action:     action,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rec.status,
+			RemoteAddr: ClientIP(r),
+		}
+		if claims, ok := GetUserClaimsFromContext(r.Context()); ok {
+			event.UserID = &claims.UserID
+			if claims.ServiceAccountID != 0 {
+				event.ServiceAccountID = &claims.ServiceAccountID
+			}
+		}
+
+		if err := database.CreateAuditEvent(event); err != nil {
+			log.Printf("Failed to record audit event %q: %v", action, err)
+		}
+	})
+}
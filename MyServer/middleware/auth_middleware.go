@@ -2,11 +2,15 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
 	"github.com/anurag/magicgate/MyServer/utils"
 )
 
@@ -18,7 +22,9 @@ const (
 	AuthenticatedUserKey UserContextKey = "authenticatedUser"
 )
 
-// AuthMiddleware validates JWT tokens and adds user claims to the request context
+// AuthMiddleware authenticates the request and adds the caller's claims to the request context.
+// It accepts a session access token ("Authorization: Bearer <token>") or a service account's
+// API key ("Authorization: ApiKey <key>"), which acts on behalf of the account's owner.
 func AuthMiddleware(cfg *config.Config, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -28,15 +34,19 @@ func AuthMiddleware(cfg *config.Config, next http.Handler) http.Handler {
 		}
 
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "ApiKey") {
 			RespondWithError(w, http.StatusUnauthorized, "Invalid Authorization header format")
 			return
 		}
 
-		tokenString := parts[1]
-		claims, err := utils.ValidateJWT(tokenString, cfg.JWTSecret)
-		if err != nil {
-			RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token: "+err.Error())
+		var claims *utils.Claims
+		var ok bool
+		if parts[0] == "ApiKey" {
+			claims, ok = authenticateAPIKey(w, r, parts[1])
+		} else {
+			claims, ok = authenticateAccessToken(w, r, cfg, parts[1])
+		}
+		if !ok {
 			return
 		}
 
@@ -46,6 +56,167 @@ func AuthMiddleware(cfg *config.Config, next http.Handler) http.Handler {
 	})
 }
 
+// passwordChangePaths are the only paths users who must change their password can reach
+var passwordChangePaths = map[string]bool{
+	"/api/me/password": true,
+	"/logout":          true,
+}
+
+// authenticateAccessToken validates a session access token and returns its claims.
+// On failure it writes the error response and returns false.
+func authenticateAccessToken(w http.ResponseWriter, r *http.Request, cfg *config.Config, tokenString string) (*utils.Claims, bool) {
+	claims, err := utils.ValidateJWT(tokenString, cfg.SessionTokenIssuer, sessionVerificationKey)
+	if err != nil {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token: "+err.Error())
+		return nil, false
+	}
+
+	// Tokens of deleted users, and tokens issued before a password change, are no longer accepted
+	user, err := database.GetUserByID(claims.UserID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if user == nil || user.TokenVersion != claims.TokenVersion {
+		RespondWithError(w, http.StatusUnauthorized, "Token has been revoked")
+		return nil, false
+	}
+	if user.PasswordChangeRequired && !passwordChangePaths[r.URL.Path] {
+		RespondWithError(w, http.StatusForbidden, "Password change required: POST /api/me/password")
+		return nil, false
+	}
+	// The role is taken from the user row, not the token, so a role change applies at once
+	claims.Role = user.Role
+
+	// The token's jti names its session; tokens of logged-out or revoked sessions are refused
+	session, err := database.GetSession(claims.ID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if session == nil || session.UserID != claims.UserID || session.RevokedAt != nil || !session.ExpiresAt.After(time.Now()) {
+		RespondWithError(w, http.StatusUnauthorized, "Session has been revoked")
+		return nil, false
+	}
+	return claims, true
+}
+
+// authenticateAPIKey checks a service account's API key and returns claims for the account's owner,
+// limited to the key's scopes and to the plain user role. On failure it writes the error response
+// and returns false.
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string) (*utils.Claims, bool) {
+	prefix, secretHash, ok := utils.ParseAPIKey(key)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		return nil, false
+	}
+
+	apiKey, err := database.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if apiKey == nil || subtle.ConstantTimeCompare(apiKey.SecretHash, secretHash) != 1 {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		return nil, false
+	}
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(time.Now()) {
+		RespondWithError(w, http.StatusUnauthorized, "API key has expired")
+		return nil, false
+	}
+
+	serviceAccount, err := database.GetServiceAccountByID(apiKey.ServiceAccountID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if serviceAccount == nil {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		return nil, false
+	}
+	owner, err := database.GetUserByID(serviceAccount.OwnerID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if owner == nil {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		return nil, false
+	}
+
+	if err := database.TouchAPIKey(apiKey.ID, ClientIP(r)); err != nil {
+		log.Printf("Failed to record use of API key %s: %v", apiKey.Prefix, err)
+	}
+
+	return &utils.Claims{
+		UserID:           owner.ID,
+		Username:         owner.Username,
+		Role:             database.RoleUser,
+		ServiceAccountID: serviceAccount.ID,
+		Scopes:           apiKey.Scopes,
+	}, true
+}
+
+// RequireScope wraps next so that API key callers reach it only if their key has the given scope.
+// Session callers are not limited by scopes. It must run after AuthMiddleware.
+func RequireScope(next http.Handler, scope string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetUserClaimsFromContext(r.Context())
+		if !ok {
+			RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+		if claims.ServiceAccountID != 0 {
+			for _, s := range claims.Scopes {
+				if s == scope {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			RespondWithError(w, http.StatusForbidden, "Forbidden: API key lacks scope "+scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSession wraps next so that only callers with a session access token reach it, not API keys.
+// It guards account management, so a leaked API key can't be used to take over its owner's account.
+// It must run after AuthMiddleware.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetUserClaimsFromContext(r.Context())
+		if !ok {
+			RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+		if claims.ServiceAccountID != 0 {
+			RespondWithError(w, http.StatusForbidden, "Forbidden: not available to API keys")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole wraps next so that only callers whose current role is one of the given roles reach it.
+// It must run after AuthMiddleware so the caller's claims are available.
+func RequireRole(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetUserClaimsFromContext(r.Context())
+		if !ok {
+			RespondWithError(w, http.StatusUnauthorized, "Unauthorized: User claims not found")
+			return
+		}
+		for _, role := range roles {
+			if claims.Role == role {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		RespondWithError(w, http.StatusForbidden, "Forbidden: requires role "+strings.Join(roles, " or "))
+	})
+}
+
 // GetUserClaimsFromContext retrieves user claims from the request context
 func GetUserClaimsFromContext(ctx context.Context) (*utils.Claims, bool) {
 	claims, ok := ctx.Value(AuthenticatedUserKey).(*utils.Claims)
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucketIdleTimeout is how long an unused token bucket is kept before being discarded
+const bucketIdleTimeout = 10 * time.Minute
+
+// tokenBucket tracks the remaining request allowance of a single caller
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter limits each caller to a sustained number of requests per second with a burst allowance.
+// Callers are identified by their authenticated user ID, or by remote address when unauthenticated.
+// State is kept in memory, so limits apply per server instance.
+type RateLimiter struct {
+	rate      float64 // Tokens added per second
+	burst     float64 // Maximum tokens a bucket can hold
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing ratePerSecond requests per second with bursts of up to burst
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:      ratePerSecond,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the caller's bucket. If none is left, it returns false and how long
+// until the next token is available.
+func (rl *RateLimiter) allow(caller string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > bucketIdleTimeout {
+		for id, bucket := range rl.buckets {
+			if now.Sub(bucket.lastSeen) > bucketIdleTimeout {
+				delete(rl.buckets, id)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	bucket, ok := rl.buckets[caller]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, lastSeen: now}
+		rl.buckets[caller] = bucket
+	}
+
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*rl.rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Limit wraps next so that callers exceeding the rate limit get 429 Too Many Requests.
+// It must run after AuthMiddleware so callers are limited per user.
+func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := "addr:" + ClientIP(r)
+		if claims, ok := GetUserClaimsFromContext(r.Context()); ok {
+			caller = "user:" + strconv.Itoa(claims.UserID)
+		}
+
+		if allowed, retryAfter := rl.allow(caller); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			RespondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the IP address of the client that sent r, without the port.
+// Forwarding headers are ignored since they can be set by the client.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
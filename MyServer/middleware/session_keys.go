@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// Session signing keys are cached in memory so requests don't each hit the database, and reloaded
+// periodically so keys rotated by another server instance are picked up
+const (
+	sessionKeyReloadInterval = time.Minute
+	// sessionKeyMinReloadInterval bounds the reloads triggered by tokens with an unknown kid
+	sessionKeyMinReloadInterval = 10 * time.Second
+)
+
+// sessionKey is a session signing key with its parsed private key
+type sessionKey struct {
+	database.SessionSigningKey
+	signer crypto.Signer
+}
+
+// sessionKeys caches the unexpired session signing keys, most recently activated first
+var sessionKeys struct {
+	mu       sync.Mutex
+	keys     []sessionKey
+	loadedAt time.Time
+}
+
+// loadSessionKeys returns the cached session signing keys, reloading them first if they are older than maxAge
+func loadSessionKeys(maxAge time.Duration) ([]sessionKey, error) {
+	sessionKeys.mu.Lock()
+	defer sessionKeys.mu.Unlock()
+
+	if sessionKeys.keys != nil && time.Since(sessionKeys.loadedAt) < maxAge {
+		return sessionKeys.keys, nil
+	}
+
+	records, err := database.GetSessionSigningKeys(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]sessionKey, 0, len(records))
+	for _, record := range records {
+		signer, err := utils.ParsePrivateKey(record.PrivateKey)
+		if err != nil {
+			log.Printf("Error parsing session signing key %s: %v", record.KeyID, err)
+			continue
+		}
+		keys = append(keys, sessionKey{SessionSigningKey: record, signer: signer})
+	}
+	sessionKeys.keys, sessionKeys.loadedAt = keys, time.Now()
+	return keys, nil
+}
+
+// ReloadSessionKeys discards the cached session signing keys, so a rotation made by this
+// server instance takes effect on its next request
+func ReloadSessionKeys() {
+	sessionKeys.mu.Lock()
+	defer sessionKeys.mu.Unlock()
+	sessionKeys.keys = nil
+}
+
+// usable reports whether the key is unexpired at now
+func (k *sessionKey) usable(now time.Time) bool {
+	return k.ExpiresAt == nil || k.ExpiresAt.After(now)
+}
+
+// activeSessionKey returns the key that signs at now: the most recently activated unexpired key
+func activeSessionKey(keys []sessionKey, now time.Time) *sessionKey {
+	for i := range keys {
+		if !keys[i].ActivatesAt.After(now) && keys[i].usable(now) {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// SignSessionToken signs claims into an access token valid for ttl, with the active session signing key
+func SignSessionToken(claims *utils.Claims, ttl time.Duration) (string, error) {
+	keys, err := loadSessionKeys(sessionKeyReloadInterval)
+	if err != nil {
+		return "", err
+	}
+	key := activeSessionKey(keys, time.Now())
+	if key == nil {
+		// The first key may have been created since the last load
+		if keys, err = loadSessionKeys(sessionKeyMinReloadInterval); err != nil {
+			return "", err
+		}
+		if key = activeSessionKey(keys, time.Now()); key == nil {
+			return "", fmt.Errorf("no active session signing key")
+		}
+	}
+	return utils.GenerateJWT(claims, ttl, key.KeyID, key.Algorithm, key.signer)
+}
+
+// sessionVerificationKey is the utils.JWTKeyLookup for access tokens: it returns the public key
+// and algorithm of the unexpired session signing key kid
+func sessionVerificationKey(kid string) (crypto.PublicKey, string, error) {
+	find := func(keys []sessionKey) *sessionKey {
+		for i := range keys {
+			if keys[i].KeyID == kid && keys[i].usable(time.Now()) {
+				return &keys[i]
+			}
+		}
+		return nil
+	}
+
+	keys, err := loadSessionKeys(sessionKeyReloadInterval)
+	if err != nil {
+		return nil, "", err
+	}
+	key := find(keys)
+	if key == nil {
+		// The key may have been created by another server instance since the last load
+		if keys, err = loadSessionKeys(sessionKeyMinReloadInterval); err != nil {
+			return nil, "", err
+		}
+		if key = find(keys); key == nil {
+			return nil, "", fmt.Errorf("unknown or expired signing key %q", kid)
+		}
+	}
+	return key.signer.Public(), key.Algorithm, nil
+}
@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+)
+
+// StartKeyDeletion launches a background job that periodically destroys keys whose deletion
+// waiting period has ended. It returns immediately; the job stops when ctx is cancelled.
+func StartKeyDeletion(ctx context.Context, cfg *config.Config) {
+	runPeriodically(ctx, "Key deletion", cfg.KeyDeletionCheckInterval, keyDeletionLockID, destroyDueKeys)
+}
+
+// destroyDueKeys permanently deletes pending_deletion keys past their scheduled deletion time
+func destroyDueKeys() error {
+	destroyed, err := database.DestroyKeysDueForDeletion(time.Now())
+	if err != nil {
+		return err
+	}
+	if destroyed > 0 {
+		log.Printf("Destroyed %d key(s) whose deletion waiting period ended", destroyed)
+	}
+	return nil
+}
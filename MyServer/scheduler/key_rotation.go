@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// StartKeyRotation launches a background job that periodically rotates keys whose
+// rotation period has elapsed. It returns immediately; the job stops when ctx is cancelled.
+func StartKeyRotation(ctx context.Context, cfg *config.Config) {
+	runPeriodically(ctx, "Key rotation", cfg.KeyRotationCheckInterval, keyRotationLockID, rotateDueKeys)
+}
+
+// rotateDueKeys rotates every key whose next_rotation_at has passed.
+// A failure on one key is logged and does not stop the remaining keys from rotating.
+func rotateDueKeys() error {
+	keys, err := database.GetKeysDueForRotation(time.Now())
+	if err != nil {
+		return err
+	}
+
+	rotated := 0
+	for i := range keys {
+		key := &keys[i]
+
+		newKeyMaterial, err := utils.GenerateKeyMaterial(key.Algorithm)
+		if err != nil {
+			log.Printf("Failed to generate new material for key %d: %v", key.ID, err)
+			continue
+		}
+
+		if err := database.RotateKeyMaterial(key, newKeyMaterial); err != nil {
+			log.Printf("Failed to rotate key %d: %v", key.ID, err)
+			continue
+		}
+		rotated++
+	}
+
+	if rotated > 0 {
+		log.Printf("Automatically rotated %d key(s)", rotated)
+	}
+	return nil
+}
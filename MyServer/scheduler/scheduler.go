@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/database"
+)
+
+// Advisory lock IDs for background jobs, so each job runs on only one server instance at a time
+const (
+	keyRotationLockID        int64 = 0x6d67_0001
+	keyDeletionLockID        int64 = 0x6d67_0002
+	sessionKeyRotationLockID int64 = 0x6d67_0003
+)
+
+// runPeriodically launches a goroutine that runs job every interval while holding the advisory lock lockID.
+// If another instance holds the lock, that run is skipped. The goroutine stops when ctx is cancelled.
+// A non-positive interval leaves the job unscheduled.
+func runPeriodically(ctx context.Context, name string, interval time.Duration, lockID int64, job func() error) {
+	if interval <= 0 {
+		log.Printf("%s scheduler not started: interval %s is not positive", name, interval)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			acquired, err := database.WithAdvisoryLock(ctx, lockID, job)
+			if err != nil {
+				log.Printf("%s pass failed: %v", name, err)
+			} else if !acquired {
+				log.Printf("%s pass skipped: another instance holds the lock", name)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("%s scheduler started (running every %s)", name, interval)
+}
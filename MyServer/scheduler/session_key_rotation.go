@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/anurag/magicgate/MyServer/config"
+	"github.com/anurag/magicgate/MyServer/database"
+	"github.com/anurag/magicgate/MyServer/utils"
+)
+
+// ErrSessionKeyRotationInProgress is returned by RotateSessionSigningKey when another rotation holds the lock
+var ErrSessionKeyRotationInProgress = errors.New("a session signing key rotation is already in progress")
+
+// StartSessionKeyRotation makes sure a session signing key exists, then launches a background job that
+// periodically replaces the key once it has signed for SESSION_KEY_ROTATION_INTERVAL, and deletes
+// expired keys. The job stops when ctx is cancelled.
+func StartSessionKeyRotation(ctx context.Context, cfg *config.Config) {
+	job := func() error { return rotateSessionKeyIfDue(cfg) }
+
+	// A first pass runs before the server starts, so logins can be signed from the first request
+	if _, err := database.WithAdvisoryLock(ctx, sessionKeyRotationLockID, job); err != nil {
+		log.Printf("Session key rotation pass failed: %v", err)
+	}
+	runPeriodically(ctx, "Session key rotation", cfg.KeyRotationCheckInterval, sessionKeyRotationLockID, job)
+}
+
+// rotateSessionKeyIfDue deletes expired session signing keys, then adds a new key if there is none,
+// if the newest key has signed for the rotation interval, or if SESSION_SIGNING_ALGORITHM has changed
+func rotateSessionKeyIfDue(cfg *config.Config) error {
+	now := time.Now()
+	deleted, err := database.DeleteExpiredSessionSigningKeys(now)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired session signing key(s)", deleted)
+	}
+
+	keys, err := database.GetSessionSigningKeys(now)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		// Nothing has been signed yet, so the first key can sign at once
+		_, err = addSessionSigningKey(cfg, now, now)
+		return err
+	}
+
+	algorithm, err := utils.SessionKeyAlgorithm(cfg.SessionSigningAlgorithm)
+	if err != nil {
+		return err
+	}
+	newest := keys[0]
+	// The successor is published SessionKeyPublishLead ahead, so the newest key signs for the whole interval
+	due := !newest.ActivatesAt.Add(cfg.SessionKeyRotationInterval - config.SessionKeyPublishLead).After(now)
+	if !due && newest.Algorithm == algorithm {
+		return nil
+	}
+
+	activatesAt := now.Add(config.SessionKeyPublishLead)
+	_, err = addSessionSigningKey(cfg, activatesAt, activatesAt.Add(cfg.AccessTokenTTL))
+	return err
+}
+
+// RotateSessionSigningKey replaces the session signing key on demand. The new key is published first
+// and signs after config.SessionKeyPublishLead, with its predecessors verifying until their last tokens
+// expire. With immediate, as after a key compromise, it signs at once and the existing keys expire,
+// so every access token must be refreshed.
+func RotateSessionSigningKey(ctx context.Context, cfg *config.Config, immediate bool) (*database.SessionSigningKey, error) {
+	var key *database.SessionSigningKey
+	acquired, err := database.WithAdvisoryLock(ctx, sessionKeyRotationLockID, func() error {
+		now := time.Now()
+		activatesAt, predecessorsExpireAt := now.Add(config.SessionKeyPublishLead), now.Add(config.SessionKeyPublishLead+cfg.AccessTokenTTL)
+		if immediate {
+			activatesAt, predecessorsExpireAt = now, now
+		}
+		var err error
+		key, err = addSessionSigningKey(cfg, activatesAt, predecessorsExpireAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrSessionKeyRotationInProgress
+	}
+	return key, nil
+}
+
+// addSessionSigningKey generates a session signing key of the configured algorithm that signs from
+// activatesAt, and sets the existing keys to expire at predecessorsExpireAt
+func addSessionSigningKey(cfg *config.Config, activatesAt, predecessorsExpireAt time.Time) (*database.SessionSigningKey, error) {
+	algorithm, err := utils.SessionKeyAlgorithm(cfg.SessionSigningAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	kid, material, err := utils.GenerateSessionSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &database.SessionSigningKey{
+		KeyID:       kid,
+		Algorithm:   algorithm,
+		PrivateKey:  material,
+		ActivatesAt: activatesAt,
+	}
+	if err := database.AddSessionSigningKey(key, predecessorsExpireAt); err != nil {
+		return nil, err
+	}
+	log.Printf("Added session signing key %s (%s), signing from %s", kid, algorithm, activatesAt.Format(time.RFC3339))
+	return key, nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// breachedPrefixLength is the number of hex digits of a password's SHA-1 that names its range file,
+// as in the Have I Been Pwned k-anonymity range API
+const breachedPrefixLength = 5
+
+// IsBreachedPassword reports whether password appears in the breached-password list in dir. The list is
+// split into range files by SHA-1 prefix: <dir>/<PREFIX>.txt lists the remaining hex digits of every
+// breached password hash with that prefix, one "<SUFFIX>:<COUNT>" line each, so only one small file is
+// read per check. A missing range file means no breached password has that prefix.
+func IsBreachedPassword(dir, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	f, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open breached-password range %s: %w", prefix, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached-password range %s: %w", prefix, err)
+	}
+	return false, nil
+}
@@ -4,9 +4,12 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/hkdf"
 )
 
 // GenerateAESKey generates a new AES-256 key (32 bytes)
@@ -70,4 +73,15 @@ func DecryptAESGCM(base64Ciphertext string, key []byte, nonceSize int) ([]byte,
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
 	return plaintext, nil
-}
\ No newline at end of file
+}
+
+// DeriveKey derives a subkey of the given length from masterKey using HKDF-SHA256 (RFC 5869).
+// The same masterKey, salt and info always produce the same subkey, so per-context keys
+// can be recomputed on demand instead of being stored.
+func DeriveKey(masterKey, salt, info []byte, length int) ([]byte, error) {
+	derived := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, salt, info), derived); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return derived, nil
+}
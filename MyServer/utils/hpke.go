@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// HPKE (RFC 9180) algorithm identifiers of the one suite magicgate supports, in base mode:
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-256-GCM
+const (
+	hpkeKEMX25519HKDFSHA256 = 0x0020
+	hpkeKDFHKDFSHA256       = 0x0001
+	hpkeAEADAES256GCM       = 0x0002
+
+	hpkeModeBase     = 0x00
+	hpkeSecretSize   = 32 // Nsecret of DHKEM(X25519, HKDF-SHA256)
+	hpkeKeySize      = 32 // Nk of AES-256-GCM
+	hpkeNonceSize    = 12 // Nn of AES-256-GCM
+	hpkeEncSize      = 32 // Nenc of DHKEM(X25519, HKDF-SHA256)
+	hpkeVersionLabel = "HPKE-v1"
+)
+
+// HPKESuite names the HPKE suite, for clients that need to configure their own HPKE library
+const HPKESuite = "DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-256-GCM"
+
+var (
+	hpkeKEMSuiteID = binary.BigEndian.AppendUint16([]byte("KEM"), hpkeKEMX25519HKDFSHA256)
+	hpkeSuiteID    = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(
+		binary.BigEndian.AppendUint16([]byte("HPKE"), hpkeKEMX25519HKDFSHA256), hpkeKDFHKDFSHA256), hpkeAEADAES256GCM)
+)
+
+// hpkeLabeledExtract is LabeledExtract from RFC 9180 section 4
+func hpkeLabeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeledIKM := append(append(append([]byte(hpkeVersionLabel), suiteID...), label...), ikm...)
+	return hkdf.Extract(sha256.New, labeledIKM, salt)
+}
+
+// hpkeLabeledExpand is LabeledExpand from RFC 9180 section 4
+func hpkeLabeledExpand(suiteID, prk []byte, label string, info []byte, length int) ([]byte, error) {
+	labeledInfo := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeledInfo = append(append(append(append(labeledInfo, hpkeVersionLabel...), suiteID...), label...), info...)
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, labeledInfo), out); err != nil {
+		return nil, fmt.Errorf("failed to expand HPKE secret: %w", err)
+	}
+	return out, nil
+}
+
+// hpkeSharedSecret is ExtractAndExpand of DHKEM: it turns a DH output into the KEM shared secret
+func hpkeSharedSecret(dh, enc, recipientPublicKey []byte) ([]byte, error) {
+	prk := hpkeLabeledExtract(hpkeKEMSuiteID, nil, "eae_prk", dh)
+	kemContext := append(append([]byte{}, enc...), recipientPublicKey...)
+	return hpkeLabeledExpand(hpkeKEMSuiteID, prk, "shared_secret", kemContext, hpkeSecretSize)
+}
+
+// hpkeContext runs the base mode key schedule and returns the AEAD and nonce for the first (only) message
+func hpkeContext(sharedSecret, info []byte) (cipher.AEAD, []byte, error) {
+	pskIDHash := hpkeLabeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)
+	infoHash := hpkeLabeledExtract(hpkeSuiteID, nil, "info_hash", info)
+	keyScheduleContext := append(append([]byte{hpkeModeBase}, pskIDHash...), infoHash...)
+
+	secret := hpkeLabeledExtract(hpkeSuiteID, sharedSecret, "secret", nil)
+	key, err := hpkeLabeledExpand(hpkeSuiteID, secret, "key", keyScheduleContext, hpkeKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseNonce, err := hpkeLabeledExpand(hpkeSuiteID, secret, "base_nonce", keyScheduleContext, hpkeNonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	// The first message uses sequence number 0, so its nonce is base_nonce unchanged
+	return aead, baseNonce, nil
+}
+
+// HPKESeal encrypts plaintext to an X25519 public key with single-shot HPKE in base mode.
+// It returns the encapsulated key (enc) that must accompany the ciphertext.
+func HPKESeal(recipient *ecdh.PublicKey, info, aad, plaintext []byte) (enc, ciphertext []byte, err error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	dh, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	enc = ephemeral.PublicKey().Bytes()
+	sharedSecret, err := hpkeSharedSecret(dh, enc, recipient.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, nonce, err := hpkeContext(sharedSecret, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// HPKEOpen decrypts a single-shot HPKE base mode ciphertext sealed to the X25519 private key
+func HPKEOpen(recipient *ecdh.PrivateKey, enc, info, aad, ciphertext []byte) ([]byte, error) {
+	if len(enc) != hpkeEncSize {
+		return nil, fmt.Errorf("encapsulated key must be %d bytes, got %d", hpkeEncSize, len(enc))
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encapsulated key: %w", err)
+	}
+	// ECDH fails on low-order points, as RFC 9180 section 7.1.4 requires
+	dh, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encapsulated key: %w", err)
+	}
+	sharedSecret, err := hpkeSharedSecret(dh, enc, recipient.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	aead, nonce, err := hpkeContext(sharedSecret, info)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HPKE ciphertext: %w", err)
+	}
+	return plaintext, nil
+}
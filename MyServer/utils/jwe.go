@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JWE key management algorithms (RFC 7518 section 4)
+const (
+	JWEAlgDirect        = "dir"            // The AES key itself is the content encryption key
+	JWEAlgA256KW        = "A256KW"         // AES Key Wrap of a random content encryption key
+	JWEAlgRSAOAEP256    = "RSA-OAEP-256"   // RSAES-OAEP with SHA-256
+	JWEAlgECDHESA256KW  = "ECDH-ES+A256KW" // Ephemeral-static ECDH, then AES Key Wrap
+	JWEEncA256GCM       = "A256GCM"        // The only supported content encryption algorithm
+	jweContentKeySize   = 32
+	jweContentNonceSize = 12
+)
+
+// ErrJWEDecryptionFailed is returned for any JWE that doesn't decrypt. It deliberately doesn't say
+// which step failed, so the endpoint can't be used as a padding or key-unwrap oracle.
+var ErrJWEDecryptionFailed = errors.New("JWE decryption failed")
+
+// JWEHeader is the protected header of a JWE
+type JWEHeader struct {
+	Algorithm          string   `json:"alg"`
+	Encryption         string   `json:"enc"`
+	KeyID              string   `json:"kid,omitempty"`
+	ContentType        string   `json:"cty,omitempty"`
+	EphemeralPublicKey *JWK     `json:"epk,omitempty"`
+	PartyUInfo         string   `json:"apu,omitempty"`
+	PartyVInfo         string   `json:"apv,omitempty"`
+	Compression        string   `json:"zip,omitempty"`
+	Critical           []string `json:"crit,omitempty"`
+}
+
+// JWEAlgorithms returns the JWE key management algorithms usable with keys of the given algorithm.
+// The first one is the default.
+func JWEAlgorithms(keyAlgorithm string) []string {
+	switch keyAlgorithm {
+	case AlgorithmAES256GCM:
+		return []string{JWEAlgA256KW, JWEAlgDirect}
+	case AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		return []string{JWEAlgRSAOAEP256}
+	case AlgorithmECP256, AlgorithmECP384, AlgorithmECP521:
+		return []string{JWEAlgECDHESA256KW}
+	}
+	return nil
+}
+
+// isJWEAlgorithmFor reports whether alg can be used with keys of the given algorithm
+func isJWEAlgorithmFor(alg, keyAlgorithm string) bool {
+	for _, supported := range JWEAlgorithms(keyAlgorithm) {
+		if alg == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptJWE encrypts plaintext into a compact JWE with A256GCM content encryption.
+// The content encryption key is protected with alg, using a stored key of keyAlgorithm.
+func EncryptJWE(plaintext []byte, alg, kid, contentType, keyAlgorithm string, material []byte) (string, error) {
+	if !isJWEAlgorithmFor(alg, keyAlgorithm) {
+		return "", fmt.Errorf("JWE algorithm %s cannot be used with %s keys", alg, keyAlgorithm)
+	}
+
+	header := JWEHeader{Algorithm: alg, Encryption: JWEEncA256GCM, KeyID: kid, ContentType: contentType}
+	var cek, encryptedKey []byte
+	var err error
+
+	switch alg {
+	case JWEAlgDirect:
+		cek = material
+	case JWEAlgA256KW:
+		if cek, err = GenerateRandomBytes(jweContentKeySize); err != nil {
+			return "", err
+		}
+		if encryptedKey, err = WrapKeyAES(material, cek); err != nil {
+			return "", err
+		}
+	case JWEAlgRSAOAEP256:
+		if cek, err = GenerateRandomBytes(jweContentKeySize); err != nil {
+			return "", err
+		}
+		signer, err := ParsePrivateKey(material)
+		if err != nil {
+			return "", err
+		}
+		publicKey, ok := signer.Public().(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("key is not an RSA key")
+		}
+		if encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, cek, nil); err != nil {
+			return "", fmt.Errorf("failed to encrypt content key: %w", err)
+		}
+	case JWEAlgECDHESA256KW:
+		if cek, err = GenerateRandomBytes(jweContentKeySize); err != nil {
+			return "", err
+		}
+		recipient, err := ecdhPrivateKey(material)
+		if err != nil {
+			return "", err
+		}
+		ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		z, err := ephemeral.ECDH(recipient.PublicKey())
+		if err != nil {
+			return "", fmt.Errorf("failed to compute shared secret: %w", err)
+		}
+		epk, err := ecdhPublicJWK(ephemeral.PublicKey())
+		if err != nil {
+			return "", err
+		}
+		header.EphemeralPublicKey = &epk
+		kek := concatKDF(z, alg, nil, nil, jweContentKeySize)
+		if encryptedKey, err = WrapKeyAES(kek, cek); err != nil {
+			return "", err
+		}
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWE header: %w", err)
+	}
+	protected := base64URL(headerJSON)
+
+	gcm, err := newAESGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv, err := GenerateRandomBytes(jweContentNonceSize)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+
+	return strings.Join([]string{protected, base64URL(encryptedKey), base64URL(iv), base64URL(ciphertext), base64URL(tag)}, "."), nil
+}
+
+// ParseJWEHeader decodes the protected header of a compact JWE, so the caller can pick the key to decrypt it with
+func ParseJWEHeader(token string) (*JWEHeader, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("JWE must have 5 parts, got %d", len(parts))
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWE header encoding: %w", err)
+	}
+	header := &JWEHeader{}
+	if err := json.Unmarshal(headerJSON, header); err != nil {
+		return nil, fmt.Errorf("invalid JWE header: %w", err)
+	}
+	if header.Encryption != JWEEncA256GCM {
+		return nil, fmt.Errorf("unsupported JWE content encryption %q", header.Encryption)
+	}
+	if header.Compression != "" {
+		return nil, fmt.Errorf("unsupported JWE compression %q", header.Compression)
+	}
+	if len(header.Critical) > 0 {
+		return nil, fmt.Errorf("unsupported critical JWE header parameters %v", header.Critical)
+	}
+	return header, nil
+}
+
+// DecryptJWE decrypts a compact JWE with a stored key of keyAlgorithm. The header's alg must be
+// usable with that key type, so a token can't switch a key to a different algorithm.
+func DecryptJWE(token, keyAlgorithm string, material []byte) ([]byte, error) {
+	header, err := ParseJWEHeader(token)
+	if err != nil {
+		return nil, err
+	}
+	if !isJWEAlgorithmFor(header.Algorithm, keyAlgorithm) {
+		return nil, fmt.Errorf("JWE algorithm %s cannot be used with %s keys", header.Algorithm, keyAlgorithm)
+	}
+
+	parts := strings.Split(token, ".")
+	var decoded [4][]byte
+	for i, part := range parts[1:] {
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, fmt.Errorf("invalid JWE encoding: %w", err)
+		}
+	}
+	encryptedKey, iv, ciphertext, tag := decoded[0], decoded[1], decoded[2], decoded[3]
+
+	var cek []byte
+	switch header.Algorithm {
+	case JWEAlgDirect:
+		if len(encryptedKey) != 0 {
+			return nil, fmt.Errorf("JWE with alg dir must have an empty encrypted key")
+		}
+		cek = material
+	case JWEAlgA256KW:
+		cek, err = UnwrapKeyAES(material, encryptedKey)
+	case JWEAlgRSAOAEP256:
+		privateKey, parseErr := ParsePrivateKey(material)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		rsaKey, ok := privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("key is not an RSA key")
+		}
+		cek, err = rsa.DecryptOAEP(sha256.New(), nil, rsaKey, encryptedKey, nil)
+	case JWEAlgECDHESA256KW:
+		cek, err = unwrapECDHES(header, material, encryptedKey)
+	}
+	if err != nil || len(cek) != jweContentKeySize {
+		return nil, ErrJWEDecryptionFailed
+	}
+
+	gcm, err := newAESGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, ErrJWEDecryptionFailed
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, ErrJWEDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// unwrapECDHES recovers the content encryption key of an ECDH-ES+A256KW JWE
+func unwrapECDHES(header *JWEHeader, material, encryptedKey []byte) ([]byte, error) {
+	recipient, err := ecdhPrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+	if header.EphemeralPublicKey == nil {
+		return nil, fmt.Errorf("JWE header is missing epk")
+	}
+	ephemeral, err := ecdhPublicKeyFromJWK(recipient.Curve(), header.EphemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	z, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	apu, err := base64.RawURLEncoding.DecodeString(header.PartyUInfo)
+	if err != nil {
+		return nil, err
+	}
+	apv, err := base64.RawURLEncoding.DecodeString(header.PartyVInfo)
+	if err != nil {
+		return nil, err
+	}
+	kek := concatKDF(z, header.Algorithm, apu, apv, jweContentKeySize)
+	return UnwrapKeyAES(kek, encryptedKey)
+}
+
+// newAESGCM creates an AES-GCM AEAD for key
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// concatKDF derives a keyLength-byte key from the shared secret z with the Concat KDF of
+// NIST SP 800-56A, using the OtherInfo layout of RFC 7518 section 4.6.2
+func concatKDF(z []byte, algorithmID string, apu, apv []byte, keyLength int) []byte {
+	var otherInfo []byte
+	for _, field := range [][]byte{[]byte(algorithmID), apu, apv} {
+		otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(field)))
+		otherInfo = append(otherInfo, field...)
+	}
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keyLength*8))
+
+	var derived []byte
+	for counter := uint32(1); len(derived) < keyLength; counter++ {
+		h := sha256.New()
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(otherInfo)
+		derived = h.Sum(derived)
+	}
+	return derived[:keyLength]
+}
+
+// ecdhPrivateKey parses the PKCS#8 material of an EC key into an ECDH private key
+func ecdhPrivateKey(material []byte) (*ecdh.PrivateKey, error) {
+	signer, err := ParsePrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not an EC key")
+	}
+	privateKey, err := ecKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert EC key for ECDH: %w", err)
+	}
+	return privateKey, nil
+}
+
+// ecdhCurveNames maps ECDH curves to their JWK crv names
+var ecdhCurveNames = map[ecdh.Curve]string{
+	ecdh.P256(): "P-256",
+	ecdh.P384(): "P-384",
+	ecdh.P521(): "P-521",
+}
+
+// ecdhPublicJWK converts an ECDH public key into an EC JWK, for use as a JWE epk
+func ecdhPublicJWK(publicKey *ecdh.PublicKey) (JWK, error) {
+	crv, ok := ecdhCurveNames[publicKey.Curve()]
+	if !ok {
+		return JWK{}, fmt.Errorf("unsupported ECDH curve")
+	}
+	// Bytes returns the uncompressed point: 0x04 || X || Y
+	point := publicKey.Bytes()
+	size := (len(point) - 1) / 2
+	return JWK{KeyType: "EC", Curve: crv, X: base64URL(point[1 : 1+size]), Y: base64URL(point[1+size:])}, nil
+}
+
+// ecdhPublicKeyFromJWK parses an EC JWK on the given curve into an ECDH public key.
+// NewPublicKey rejects points that aren't on the curve, which invalid-curve attacks rely on.
+func ecdhPublicKeyFromJWK(curve ecdh.Curve, jwk *JWK) (*ecdh.PublicKey, error) {
+	if jwk.KeyType != "EC" || jwk.Curve != ecdhCurveNames[curve] {
+		return nil, fmt.Errorf("epk must be an EC key on curve %s", ecdhCurveNames[curve])
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid epk x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid epk y: %w", err)
+	}
+	point := append(append([]byte{0x04}, x...), y...)
+	publicKey, err := curve.NewPublicKey(point)
+	if err != nil {
+		return nil, fmt.Errorf("invalid epk: %w", err)
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding a public key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP public key parameters
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set, as served from a jwks.json endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// base64URL encodes b without padding, as JOSE requires
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PublicJWK converts a public key into a JWK with the given kid, alg and use
+func PublicJWK(publicKey crypto.PublicKey, kid, alg, use string) (JWK, error) {
+	jwk := JWK{KeyID: kid, Algorithm: alg, Use: use}
+
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64URL(pub.N.Bytes())
+		jwk.E = base64URL(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Coordinates are padded to the curve size, as RFC 7518 section 6.2.1.2 requires
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64URL(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64URL(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64URL(pub)
+	case *ecdh.PublicKey:
+		if pub.Curve() != ecdh.X25519() {
+			return JWK{}, fmt.Errorf("unsupported ECDH curve")
+		}
+		jwk.KeyType = "OKP"
+		jwk.Curve = "X25519"
+		jwk.X = base64URL(pub.Bytes())
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return jwk, nil
+}
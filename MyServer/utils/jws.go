@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTSigningMethod returns the JWS signing method ("alg") used with keys of the given algorithm
+func JWTSigningMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmHMACSHA256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmHMACSHA384:
+		return jwt.SigningMethodHS384, nil
+	case AlgorithmHMACSHA512:
+		return jwt.SigningMethodHS512, nil
+	case AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmECP256:
+		return jwt.SigningMethodES256, nil
+	case AlgorithmECP384:
+		return jwt.SigningMethodES384, nil
+	case AlgorithmECP521:
+		return jwt.SigningMethodES512, nil
+	case AlgorithmEd25519:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("key algorithm %s cannot sign tokens", algorithm)
+}
+
+// JWTSigningKey converts stored key material into the key golang-jwt expects for signing
+func JWTSigningKey(algorithm string, material []byte) (interface{}, error) {
+	if !IsAsymmetricAlgorithm(algorithm) {
+		return material, nil
+	}
+	return ParsePrivateKey(material)
+}
+
+// JWTVerificationKey converts stored key material into the key golang-jwt expects for verification:
+// the shared secret for HMAC keys, the public key otherwise
+func JWTVerificationKey(algorithm string, material []byte) (interface{}, error) {
+	if !IsAsymmetricAlgorithm(algorithm) {
+		return material, nil
+	}
+	signer, err := ParsePrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// SignJWTWithKey signs claims with a stored key, setting the kid header so verifiers can find the key
+func SignJWTWithKey(claims jwt.MapClaims, kid, algorithm string, material []byte) (string, error) {
+	method, err := JWTSigningMethod(algorithm)
+	if err != nil {
+		return "", err
+	}
+	signingKey, err := JWTSigningKey(algorithm, material)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	tokenString, err := token.SignedString(signingKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return tokenString, nil
+}
@@ -1,49 +1,72 @@
 package utils
 
 import (
+	"crypto"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Claims defines the JWT claims
+// Claims defines the JWT claims. The registered jti claim (ID) is the ID of the session the token belongs to.
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	// TokenVersion must match the user's current token version, which a password change bumps
+	TokenVersion int `json:"token_version"`
+	// ServiceAccountID and Scopes are set only for requests authenticated with an API key,
+	// which act on behalf of the service account's owner
+	ServiceAccountID int      `json:"service_account_id,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT generates a new JWT token
-func GenerateJWT(userID int, username, secret string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
-	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+// JWTKeyLookup returns the public key and key algorithm of the signing key named by a token's kid header
+type JWTKeyLookup func(kid string) (crypto.PublicKey, string, error)
+
+// GenerateJWT signs claims into an access token valid for ttl, with the signing key kid of the given
+// key algorithm (Ed25519 or EC-P256). The caller sets the user claims, the issuer and the session ID;
+// the time claims are set here.
+func GenerateJWT(claims *Claims, ttl time.Duration, kid, algorithm string, signer crypto.Signer) (string, error) {
+	method, err := JWTSigningMethod(algorithm)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secret))
+	now := time.Now()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	tokenString, err := token.SignedString(signer)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
 	return tokenString, nil
 }
 
-// ValidateJWT validates a JWT token and returns the claims
-func ValidateJWT(tokenString, secret string) (*Claims, error) {
+// ValidateJWT validates an access token from issuer against the key that lookup returns for its kid,
+// and returns the claims. The token's alg must be the one that key signs with.
+func ValidateJWT(tokenString, issuer string, lookup JWTKeyLookup) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		publicKey, algorithm, err := lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		method, err := JWTSigningMethod(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(secret), nil
-	})
+		return publicKey, nil
+	}, jwt.WithIssuer(issuer), jwt.WithExpirationRequired())
 
 	if err != nil || !token.Valid {
 		return nil, fmt.Errorf("invalid or expired token: %w", err)
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha3"
+	"fmt"
+)
+
+// Key material of KEM keys is the private key seed: 64 bytes (d || z) for ML-KEM-768 (FIPS 203),
+// and 32 bytes for the X25519+ML-KEM-768 hybrid, which is X-Wing (draft-connolly-cfrg-xwing-kem,
+// also MLKEM768-X25519 in draft-ietf-hpke-pq). Both halves of the hybrid must be broken to recover
+// the shared secret, so data stays protected against a future quantum computer as well as against
+// a flaw in the newer ML-KEM.
+const (
+	xwingSeedSize = 32
+	xwingLabel    = `\./` + `/^\`
+)
+
+// IsKEMAlgorithm reports whether keys of the given algorithm are key encapsulation key pairs
+func IsKEMAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmMLKEM768 || algorithm == AlgorithmX25519MLKEM768
+}
+
+// generateKEMSeed generates the private key seed of a new KEM key
+func generateKEMSeed(algorithm string) ([]byte, error) {
+	if algorithm == AlgorithmMLKEM768 {
+		return GenerateRandomBytes(mlkem.SeedSize)
+	}
+	return GenerateRandomBytes(xwingSeedSize)
+}
+
+// xwingPrivateKey holds the two private keys X-Wing expands from its seed
+type xwingPrivateKey struct {
+	pq *mlkem.DecapsulationKey768
+	t  *ecdh.PrivateKey
+}
+
+// newXWingPrivateKey expands an X-Wing seed with SHAKE256 into the ML-KEM-768 seed and the X25519 key
+func newXWingPrivateKey(seed []byte) (*xwingPrivateKey, error) {
+	if len(seed) != xwingSeedSize {
+		return nil, fmt.Errorf("hybrid key seed must be %d bytes", xwingSeedSize)
+	}
+	expanded := make([]byte, mlkem.SeedSize+32)
+	shake := sha3.NewSHAKE256()
+	shake.Write(seed)
+	shake.Read(expanded)
+
+	pq, err := mlkem.NewDecapsulationKey768(expanded[:mlkem.SeedSize])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ML-KEM key: %w", err)
+	}
+	t, err := ecdh.X25519().NewPrivateKey(expanded[mlkem.SeedSize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create X25519 key: %w", err)
+	}
+	return &xwingPrivateKey{pq: pq, t: t}, nil
+}
+
+// xwingSharedSecret is the X-Wing combiner
+func xwingSharedSecret(ssPQ, ssT, ctT, pkT []byte) []byte {
+	h := sha3.New256()
+	h.Write(ssPQ)
+	h.Write(ssT)
+	h.Write(ctT)
+	h.Write(pkT)
+	h.Write([]byte(xwingLabel))
+	return h.Sum(nil)
+}
+
+// KEMPublicKey returns the encapsulation key of a KEM key's material: the ML-KEM-768 encapsulation key,
+// or for the hybrid, the ML-KEM-768 encapsulation key followed by the X25519 public key
+func KEMPublicKey(algorithm string, material []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmMLKEM768:
+		dk, err := mlkem.NewDecapsulationKey768(material)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ML-KEM key: %w", err)
+		}
+		return dk.EncapsulationKey().Bytes(), nil
+	case AlgorithmX25519MLKEM768:
+		sk, err := newXWingPrivateKey(material)
+		if err != nil {
+			return nil, err
+		}
+		return append(sk.pq.EncapsulationKey().Bytes(), sk.t.PublicKey().Bytes()...), nil
+	}
+	return nil, fmt.Errorf("key algorithm %s is not a KEM", algorithm)
+}
+
+// KEMEncapsulate generates a fresh 32-byte shared secret for the holder of publicKey, and the
+// ciphertext from which only that holder can recover it
+func KEMEncapsulate(algorithm string, publicKey []byte) (sharedSecret, ciphertext []byte, err error) {
+	switch algorithm {
+	case AlgorithmMLKEM768:
+		ek, err := mlkem.NewEncapsulationKey768(publicKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ML-KEM public key: %w", err)
+		}
+		sharedSecret, ciphertext = ek.Encapsulate()
+		return sharedSecret, ciphertext, nil
+
+	case AlgorithmX25519MLKEM768:
+		if len(publicKey) != mlkem.EncapsulationKeySize768+32 {
+			return nil, nil, fmt.Errorf("invalid hybrid public key size")
+		}
+		ekPQ, err := mlkem.NewEncapsulationKey768(publicKey[:mlkem.EncapsulationKeySize768])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ML-KEM public key: %w", err)
+		}
+		pkT, err := ecdh.X25519().NewPublicKey(publicKey[mlkem.EncapsulationKeySize768:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid X25519 public key: %w", err)
+		}
+
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		ssT, err := ephemeral.ECDH(pkT)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to compute shared secret: %w", err)
+		}
+		ctT := ephemeral.PublicKey().Bytes()
+		ssPQ, ctPQ := ekPQ.Encapsulate()
+		return xwingSharedSecret(ssPQ, ssT, ctT, pkT.Bytes()), append(ctPQ, ctT...), nil
+	}
+	return nil, nil, fmt.Errorf("key algorithm %s is not a KEM", algorithm)
+}
+
+// KEMDecapsulate recovers the shared secret encapsulated in ciphertext with a KEM key's material.
+// ML-KEM decapsulation of a tampered ciphertext yields an unrelated secret rather than an error,
+// so callers must authenticate whatever they protect with the secret.
+func KEMDecapsulate(algorithm string, material, ciphertext []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmMLKEM768:
+		dk, err := mlkem.NewDecapsulationKey768(material)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ML-KEM key: %w", err)
+		}
+		sharedSecret, err := dk.Decapsulate(ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ciphertext: %w", err)
+		}
+		return sharedSecret, nil
+
+	case AlgorithmX25519MLKEM768:
+		if len(ciphertext) != mlkem.CiphertextSize768+32 {
+			return nil, fmt.Errorf("invalid ciphertext: must be %d bytes", mlkem.CiphertextSize768+32)
+		}
+		sk, err := newXWingPrivateKey(material)
+		if err != nil {
+			return nil, err
+		}
+		ctPQ, ctT := ciphertext[:mlkem.CiphertextSize768], ciphertext[mlkem.CiphertextSize768:]
+		ssPQ, err := sk.pq.Decapsulate(ctPQ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ciphertext: %w", err)
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(ctT)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ciphertext: %w", err)
+		}
+		ssT, err := sk.t.ECDH(ephemeral)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ciphertext: %w", err)
+		}
+		return xwingSharedSecret(ssPQ, ssT, ctT, sk.t.PublicKey().Bytes()), nil
+	}
+	return nil, fmt.Errorf("key algorithm %s is not a KEM", algorithm)
+}
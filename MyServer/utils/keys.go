@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// Key algorithms. Symmetric keys are stored as raw bytes, asymmetric keys as PKCS#8 DER private keys
+// and KEM keys as private key seeds.
+const (
+	AlgorithmAES256GCM  = "AES-256-GCM"
+	AlgorithmHMACSHA256 = "HMAC-SHA256"
+	AlgorithmHMACSHA384 = "HMAC-SHA384"
+	AlgorithmHMACSHA512 = "HMAC-SHA512"
+	AlgorithmRSA2048    = "RSA-2048"
+	AlgorithmRSA3072    = "RSA-3072"
+	AlgorithmRSA4096    = "RSA-4096"
+	AlgorithmECP256     = "EC-P256"
+	AlgorithmECP384     = "EC-P384"
+	AlgorithmECP521     = "EC-P521"
+	AlgorithmEd25519    = "Ed25519"
+	AlgorithmX25519     = "X25519" // Key agreement only, for HPKE
+
+	// Key encapsulation only, for wrapping data keys
+	AlgorithmMLKEM768       = "ML-KEM-768"
+	AlgorithmX25519MLKEM768 = "X25519-ML-KEM-768"
+)
+
+// IsAsymmetricAlgorithm reports whether keys of the given algorithm are public/private key pairs
+func IsAsymmetricAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096,
+		AlgorithmECP256, AlgorithmECP384, AlgorithmECP521, AlgorithmEd25519, AlgorithmX25519:
+		return true
+	}
+	return false
+}
+
+// GenerateKeyMaterial generates new key material for the given algorithm
+func GenerateKeyMaterial(algorithm string) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmAES256GCM:
+		return GenerateAESKey()
+	case AlgorithmHMACSHA256:
+		return GenerateRandomBytes(32)
+	case AlgorithmHMACSHA384:
+		return GenerateRandomBytes(48)
+	case AlgorithmHMACSHA512:
+		return GenerateRandomBytes(64)
+	case AlgorithmMLKEM768, AlgorithmX25519MLKEM768:
+		return generateKEMSeed(algorithm)
+	}
+
+	var privateKey interface{}
+	var err error
+	switch algorithm {
+	case AlgorithmRSA2048:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmRSA3072:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 3072)
+	case AlgorithmRSA4096:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 4096)
+	case AlgorithmECP256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmECP384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgorithmECP521:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case AlgorithmEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmX25519:
+		privateKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", algorithm, err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s key: %w", algorithm, err)
+	}
+	return der, nil
+}
+
+// ParsePrivateKey parses the PKCS#8 DER material of a signing key into a crypto.Signer
+func ParsePrivateKey(material []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(material)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParseX25519PrivateKey parses the PKCS#8 DER material of an X25519 key
+func ParseX25519PrivateKey(material []byte) (*ecdh.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(material)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	privateKey, ok := key.(*ecdh.PrivateKey)
+	if !ok || privateKey.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("key is not an X25519 key")
+	}
+	return privateKey, nil
+}
+
+// PublicKey returns the public half of an asymmetric key's material
+func PublicKey(algorithm string, material []byte) (crypto.PublicKey, error) {
+	if algorithm == AlgorithmX25519 {
+		privateKey, err := ParseX25519PrivateKey(material)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey.PublicKey(), nil
+	}
+	if !IsAsymmetricAlgorithm(algorithm) {
+		return nil, fmt.Errorf("key algorithm %s has no public key", algorithm)
+	}
+	signer, err := ParsePrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
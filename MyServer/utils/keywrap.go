@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// keyWrapIV is the default initial value of AES Key Wrap (RFC 3394 section 2.2.3.1)
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// ErrKeyUnwrapFailed is returned when wrapped key data fails its integrity check
+var ErrKeyUnwrapFailed = errors.New("key unwrap failed: integrity check failed")
+
+// WrapKeyAES wraps keyData, a multiple of 8 bytes and at least 16 bytes long, under kek with AES Key Wrap (RFC 3394)
+func WrapKeyAES(kek, keyData []byte) ([]byte, error) {
+	if len(keyData) < 16 || len(keyData)%8 != 0 {
+		return nil, fmt.Errorf("key data must be a multiple of 8 bytes and at least 16 bytes, got %d", len(keyData))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	return wrapBlocks(block, keyWrapIV, keyData), nil
+}
+
+// UnwrapKeyAES unwraps key data wrapped by WrapKeyAES, checking its integrity
+func UnwrapKeyAES(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("wrapped key must be a multiple of 8 bytes and at least 24 bytes, got %d", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	iv, keyData := unwrapBlocks(block, wrapped)
+	if subtle.ConstantTimeCompare(iv, keyWrapIV) != 1 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	return keyData, nil
+}
+
+// keyWrapPadICV is the 32-bit constant that starts the alternative initial value of AES Key Wrap with
+// Padding (RFC 5649 section 3); the message length indicator (MLI) follows it
+var keyWrapPadICV = []byte{0xA6, 0x59, 0x59, 0xA6}
+
+// WrapKeyAESPad wraps keyData of any length from 1 byte under kek with AES Key Wrap with Padding (RFC 5649)
+func WrapKeyAESPad(kek, keyData []byte) ([]byte, error) {
+	if len(keyData) == 0 || uint64(len(keyData)) > 0xFFFFFFFF {
+		return nil, fmt.Errorf("key data must be between 1 byte and 2^32-1 bytes, got %d", len(keyData))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	iv := binary.BigEndian.AppendUint32(append([]byte{}, keyWrapPadICV...), uint32(len(keyData)))
+	padded := make([]byte, (len(keyData)+7)/8*8)
+	copy(padded, keyData)
+
+	// A single padded block is encrypted together with the initial value in one AES operation
+	if len(padded) == 8 {
+		out := append(iv, padded...)
+		block.Encrypt(out, out)
+		return out, nil
+	}
+	return wrapBlocks(block, iv, padded), nil
+}
+
+// UnwrapKeyAESPad unwraps key data wrapped by WrapKeyAESPad, checking its integrity, length and padding
+func UnwrapKeyAESPad(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("wrapped key must be a multiple of 8 bytes and at least 16 bytes, got %d", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	var iv, padded []byte
+	if len(wrapped) == 16 {
+		out := make([]byte, 16)
+		block.Decrypt(out, wrapped)
+		iv, padded = out[:8], out[8:]
+	} else {
+		iv, padded = unwrapBlocks(block, wrapped)
+	}
+
+	if subtle.ConstantTimeCompare(iv[:4], keyWrapPadICV) != 1 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	length := int(binary.BigEndian.Uint32(iv[4:]))
+	if length <= len(padded)-8 || length > len(padded) {
+		return nil, ErrKeyUnwrapFailed
+	}
+	if subtle.ConstantTimeCompare(padded[length:], make([]byte, len(padded)-length)) != 1 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	return padded[:length], nil
+}
+
+// wrapBlocks runs the RFC 3394 wrapping process over the 64-bit blocks of plaintext, starting from iv
+func wrapBlocks(block cipher.Block, iv, plaintext []byte) []byte {
+	n := len(plaintext) / 8
+	a := make([]byte, 8)
+	copy(a, iv)
+	r := make([]byte, len(plaintext))
+	copy(r, plaintext)
+
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf[:8], a)
+			copy(buf[8:], r[i*8:(i+1)*8])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^uint64(n*j+i+1))
+			copy(r[i*8:(i+1)*8], buf[8:])
+		}
+	}
+	return append(a, r...)
+}
+
+// unwrapBlocks reverses wrapBlocks, returning the recovered initial value and plaintext
+func unwrapBlocks(block cipher.Block, ciphertext []byte) (iv, plaintext []byte) {
+	n := len(ciphertext)/8 - 1
+	a := make([]byte, 8)
+	copy(a, ciphertext[:8])
+	r := make([]byte, n*8)
+	copy(r, ciphertext[8:])
+
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a)^uint64(n*j+i+1))
+			copy(buf[8:], r[i*8:(i+1)*8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[i*8:(i+1)*8], buf[8:])
+		}
+	}
+	return a, r
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 3394 section 4
+var keyWrapVectors = []struct {
+	name, kek, keyData, wrapped string
+}{
+	{"4.1 128-bit data with 128-bit KEK",
+		"000102030405060708090A0B0C0D0E0F",
+		"00112233445566778899AABBCCDDEEFF",
+		"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+	{"4.2 128-bit data with 192-bit KEK",
+		"000102030405060708090A0B0C0D0E0F1011121314151617",
+		"00112233445566778899AABBCCDDEEFF",
+		"96778B25AE6CA435F92B5B97C050AED2468AB8A17AD84E5D"},
+	{"4.3 128-bit data with 256-bit KEK",
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"00112233445566778899AABBCCDDEEFF",
+		"64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7"},
+	{"4.4 192-bit data with 192-bit KEK",
+		"000102030405060708090A0B0C0D0E0F1011121314151617",
+		"00112233445566778899AABBCCDDEEFF0001020304050607",
+		"031D33264E15D33268F24EC260743EDCE1C6C7DDEE725A936BA814915C6762D2"},
+	{"4.5 192-bit data with 256-bit KEK",
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"00112233445566778899AABBCCDDEEFF0001020304050607",
+		"A8F9BC1612C68B3FF6E6F4FBE30E71E4769C8B80A32CB8958CD5D17D6B254DA1"},
+	{"4.6 256-bit data with 256-bit KEK",
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+		"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+}
+
+// RFC 5649 section 6
+var keyWrapPadVectors = []struct {
+	name, kek, keyData, wrapped string
+}{
+	{"20-octet key",
+		"5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8",
+		"c37b7e6492584340bed12207808941155068f738",
+		"138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+	{"7-octet key",
+		"5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8",
+		"466f7250617369",
+		"afbeb0f07dfbf5419200f2ccb50bb24f"},
+}
+
+func TestWrapKeyAES(t *testing.T) {
+	for _, v := range keyWrapVectors {
+		t.Run(v.name, func(t *testing.T) {
+			kek, keyData, want := mustHex(t, v.kek), mustHex(t, v.keyData), mustHex(t, v.wrapped)
+
+			wrapped, err := WrapKeyAES(kek, keyData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wrapped, want) {
+				t.Errorf("WrapKeyAES() = %X, want %X", wrapped, want)
+			}
+
+			unwrapped, err := UnwrapKeyAES(kek, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unwrapped, keyData) {
+				t.Errorf("UnwrapKeyAES() = %X, want %X", unwrapped, keyData)
+			}
+		})
+	}
+}
+
+func TestWrapKeyAESPad(t *testing.T) {
+	for _, v := range keyWrapPadVectors {
+		t.Run(v.name, func(t *testing.T) {
+			kek, keyData, want := mustHex(t, v.kek), mustHex(t, v.keyData), mustHex(t, v.wrapped)
+
+			wrapped, err := WrapKeyAESPad(kek, keyData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wrapped, want) {
+				t.Errorf("WrapKeyAESPad() = %x, want %x", wrapped, want)
+			}
+
+			unwrapped, err := UnwrapKeyAESPad(kek, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unwrapped, keyData) {
+				t.Errorf("UnwrapKeyAESPad() = %x, want %x", unwrapped, keyData)
+			}
+		})
+	}
+}
+
+func TestUnwrapKeyAESIntegrity(t *testing.T) {
+	v := keyWrapVectors[0]
+	kek, wrapped := mustHex(t, v.kek), mustHex(t, v.wrapped)
+	wrongKEK := mustHex(t, keyWrapVectors[2].kek)
+
+	for i := range wrapped {
+		tampered := append([]byte{}, wrapped...)
+		tampered[i] ^= 0x01
+		if _, err := UnwrapKeyAES(kek, tampered); !errors.Is(err, ErrKeyUnwrapFailed) {
+			t.Errorf("byte %d flipped: UnwrapKeyAES() error = %v, want ErrKeyUnwrapFailed", i, err)
+		}
+	}
+	if _, err := UnwrapKeyAES(wrongKEK, wrapped); !errors.Is(err, ErrKeyUnwrapFailed) {
+		t.Errorf("wrong KEK: UnwrapKeyAES() error = %v, want ErrKeyUnwrapFailed", err)
+	}
+	// A padded wrap has the RFC 5649 ICV, which RFC 3394 unwrapping must reject
+	padded := mustHex(t, keyWrapPadVectors[0].wrapped)
+	if _, err := UnwrapKeyAES(mustHex(t, keyWrapPadVectors[0].kek), padded); !errors.Is(err, ErrKeyUnwrapFailed) {
+		t.Errorf("RFC 5649 wrap: UnwrapKeyAES() error = %v, want ErrKeyUnwrapFailed", err)
+	}
+
+	for _, length := range []int{0, 8, 16, 25} {
+		if _, err := UnwrapKeyAES(kek, make([]byte, length)); err == nil || errors.Is(err, ErrKeyUnwrapFailed) {
+			t.Errorf("%d bytes: UnwrapKeyAES() error = %v, want a length error", length, err)
+		}
+	}
+}
+
+func TestUnwrapKeyAESPadIntegrity(t *testing.T) {
+	for _, v := range keyWrapPadVectors {
+		t.Run(v.name, func(t *testing.T) {
+			kek, wrapped := mustHex(t, v.kek), mustHex(t, v.wrapped)
+
+			for i := range wrapped {
+				tampered := append([]byte{}, wrapped...)
+				tampered[i] ^= 0x01
+				if _, err := UnwrapKeyAESPad(kek, tampered); !errors.Is(err, ErrKeyUnwrapFailed) {
+					t.Errorf("byte %d flipped: UnwrapKeyAESPad() error = %v, want ErrKeyUnwrapFailed", i, err)
+				}
+			}
+			if _, err := UnwrapKeyAESPad(mustHex(t, keyWrapVectors[2].kek), wrapped); !errors.Is(err, ErrKeyUnwrapFailed) {
+				t.Errorf("wrong KEK: UnwrapKeyAESPad() error = %v, want ErrKeyUnwrapFailed", err)
+			}
+		})
+	}
+
+	// An RFC 3394 wrap has the default IV instead of the RFC 5649 ICV
+	v := keyWrapVectors[0]
+	if _, err := UnwrapKeyAESPad(mustHex(t, v.kek), mustHex(t, v.wrapped)); !errors.Is(err, ErrKeyUnwrapFailed) {
+		t.Errorf("RFC 3394 wrap: UnwrapKeyAESPad() error = %v, want ErrKeyUnwrapFailed", err)
+	}
+
+	for _, length := range []int{0, 8, 20} {
+		if _, err := UnwrapKeyAESPad(mustHex(t, v.kek), make([]byte, length)); err == nil || errors.Is(err, ErrKeyUnwrapFailed) {
+			t.Errorf("%d bytes: UnwrapKeyAESPad() error = %v, want a length error", length, err)
+		}
+	}
+}
@@ -1,22 +1,163 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword hashes a plain-text password using bcrypt
+// Argon2idParams are the cost parameters of Argon2id password hashes
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32 // Bytes
+	KeyLength   uint32 // Bytes
+}
+
+// passwordHashing holds the parameters and pepper new password hashes are made with. It is set once
+// at startup by ConfigurePasswordHashing, before any request is served.
+var passwordHashing = struct {
+	params   Argon2idParams
+	pepper   []byte
+	pepperID string
+}{
+	// RFC 9106's second recommended option, for when ConfigurePasswordHashing isn't called
+	params: Argon2idParams{Memory: 64 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32},
+}
+
+// ConfigurePasswordHashing sets the Argon2id parameters of new password hashes, and the pepper: a secret
+// kept outside the database and mixed into every new hash, so a leaked users table alone can't be
+// brute-forced. An empty pepper disables peppering.
+func ConfigurePasswordHashing(params Argon2idParams, pepper []byte) {
+	passwordHashing.params = params
+	passwordHashing.pepper = pepper
+	passwordHashing.pepperID = ""
+	if len(pepper) > 0 {
+		// The PHC keyid names the pepper a hash was made with, without revealing it
+		sum := sha256.Sum256(pepper)
+		passwordHashing.pepperID = base64.RawStdEncoding.EncodeToString(sum[:6])
+	}
+}
+
+// pepperPassword returns the Argon2id input for password: the password itself, or its HMAC-SHA256 under
+// the pepper when the hash is peppered
+func pepperPassword(password string, peppered bool) []byte {
+	if !peppered {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, passwordHashing.pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// HashPassword hashes a plain-text password with Argon2id and the configured parameters and pepper,
+// returning it in PHC string format: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>[,keyid=<pepper ID>]$<salt>$<hash>
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	p := passwordHashing.params
+	salt, err := GenerateRandomBytes(int(p.SaltLength))
 	if err != nil {
 		return "", fmt.Errorf("failed to hash password: %w", err)
 	}
-	return string(bytes), nil
+
+	peppered := len(passwordHashing.pepper) > 0
+	key := argon2.IDKey(pepperPassword(password, peppered), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if peppered {
+		params += ",keyid=" + passwordHashing.pepperID
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2idHash is a parsed Argon2id PHC string
+type argon2idHash struct {
+	params Argon2idParams
+	keyID  string // Pepper ID, empty if unpeppered
+	salt   []byte
+	key    []byte
+}
+
+// parseArgon2idHash parses a PHC string made by HashPassword
+func parseArgon2idHash(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("not an argon2id PHC string")
+	}
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, fmt.Errorf("unsupported argon2 version %s", parts[2])
+	}
+
+	h := &argon2idHash{}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Parallelism)
+		case "keyid":
+			h.keyID = value
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid argon2id parameter %q: %w", param, err)
+		}
+	}
+	if h.params.Memory == 0 || h.params.Iterations == 0 || h.params.Parallelism == 0 {
+		return nil, fmt.Errorf("missing argon2id parameters")
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	h.params.SaltLength, h.params.KeyLength = uint32(len(h.salt)), uint32(len(h.key))
+	return h, nil
+}
+
+// VerifyPassword compares a plain-text password with a hash made by HashPassword, or with a legacy bcrypt
+// hash. needsRehash reports that a matching hash is outdated, because it is bcrypt, its parameters differ
+// from the configured ones, or it isn't made with the current pepper, so the caller should replace it
+// with HashPassword(password) while it has the password at hand.
+func VerifyPassword(password, hash string) (ok, needsRehash bool) {
+	if strings.HasPrefix(hash, "$2") {
+		ok := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+		return ok, ok
+	}
+
+	h, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, false
+	}
+	if h.keyID != "" && h.keyID != passwordHashing.pepperID {
+		// Made with a pepper that is no longer configured; it can't be checked
+		return false, false
+	}
+
+	key := argon2.IDKey(pepperPassword(password, h.keyID != ""), h.salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return false, false
+	}
+	return true, h.params != passwordHashing.params || h.keyID != passwordHashing.pepperID
 }
 
-// CheckPasswordHash compares a plain-text password with a bcrypt hash
+// CheckPasswordHash compares a plain-text password with a password hash
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	ok, _ := VerifyPassword(password, hash)
+	return ok
 }
\ No newline at end of file
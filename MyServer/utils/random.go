@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Password character classes
+const (
+	PasswordLower   = "abcdefghijklmnopqrstuvwxyz"
+	PasswordUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	PasswordDigits  = "0123456789"
+	PasswordSymbols = "!@#$%^&*()-_=+[]{};:,.<>?/~"
+)
+
+// GenerateRandomBytes returns n bytes from the operating system's CSPRNG
+func GenerateRandomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// randomIndex returns a uniformly distributed random integer in [0, n)
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random index: %w", err)
+	}
+	return int(i.Int64()), nil
+}
+
+// GeneratePassword returns a random password of the given length drawn from the given character classes.
+// It contains at least one character from every class, so length must be at least len(classes).
+func GeneratePassword(length int, classes []string) (string, error) {
+	if len(classes) == 0 {
+		return "", fmt.Errorf("at least one character class is required")
+	}
+	if length < len(classes) {
+		return "", fmt.Errorf("length %d is too short to include all %d character classes", length, len(classes))
+	}
+
+	alphabet := ""
+	for _, class := range classes {
+		alphabet += class
+	}
+
+	password := make([]byte, 0, length)
+	// One character from each class first, so every class is represented
+	for _, class := range classes {
+		i, err := randomIndex(len(class))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, class[i])
+	}
+	for len(password) < length {
+		i, err := randomIndex(len(alphabet))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, alphabet[i])
+	}
+
+	// Fisher-Yates shuffle so the guaranteed characters aren't always at the front
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// opaqueTokenSize is the number of random bytes in a refresh token or MFA challenge token
+const opaqueTokenSize = 32
+
+// GenerateSessionID returns a random session ID: 16 bytes, hex encoded
+func GenerateSessionID() (string, error) {
+	b, err := GenerateRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateRefreshToken returns a new random refresh token, and the hash under which it is stored
+func GenerateRefreshToken() (token string, hash []byte, err error) {
+	return generateOpaqueToken()
+}
+
+// GenerateMFAChallengeToken returns a new random token for the second step of an MFA login, and the
+// hash under which it is stored
+func GenerateMFAChallengeToken() (token string, hash []byte, err error) {
+	return generateOpaqueToken()
+}
+
+func generateOpaqueToken() (token string, hash []byte, err error) {
+	b, err := GenerateRandomBytes(opaqueTokenSize)
+	if err != nil {
+		return "", nil, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the SHA-256 hash under which a random token or secret is stored: refresh tokens,
+// MFA challenge tokens and recovery codes, and the secret parts of API keys and registration tokens.
+// All are high-entropy, so a fast unsalted hash is enough to make a leaked table useless.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// SessionKeyAlgorithm returns the key algorithm of session signing keys for a JWS algorithm, "EdDSA" or "ES256"
+func SessionKeyAlgorithm(alg string) (string, error) {
+	switch alg {
+	case "EdDSA":
+		return AlgorithmEd25519, nil
+	case "ES256":
+		return AlgorithmECP256, nil
+	}
+	return "", fmt.Errorf("unsupported session signing algorithm %s", alg)
+}
+
+// GenerateSessionSigningKey generates a new session signing key of the given key algorithm,
+// returning a random kid and the key's PKCS#8 material
+func GenerateSessionSigningKey(algorithm string) (kid string, material []byte, err error) {
+	material, err = GenerateKeyMaterial(algorithm)
+	if err != nil {
+		return "", nil, err
+	}
+	b, err := GenerateRandomBytes(8)
+	if err != nil {
+		return "", nil, err
+	}
+	return "session-" + hex.EncodeToString(b), material, nil
+}
+
+// Prefixes of the credentials issued by the server, so leaked ones are easy to recognise and scan for
+const (
+	APIKeyPrefix            = "mgk"
+	RegistrationTokenPrefix = "mgr"
+)
+
+// GenerateAPIKey returns a new API key of the form "mgk_<id>_<secret>", the public prefix
+// "mgk_<id>" under which it is looked up, and the hash of the secret part, which is what gets stored
+func GenerateAPIKey() (key, prefix string, secretHash []byte, err error) {
+	return generatePrefixedSecret(APIKeyPrefix)
+}
+
+// ParseAPIKey splits an API key into its prefix and the hash of its secret part
+func ParseAPIKey(key string) (prefix string, secretHash []byte, ok bool) {
+	return parsePrefixedSecret(APIKeyPrefix, key)
+}
+
+// GenerateRegistrationToken returns a new app registration token of the form "mgr_<id>_<secret>",
+// with its prefix and secret hash like GenerateAPIKey
+func GenerateRegistrationToken() (token, prefix string, secretHash []byte, err error) {
+	return generatePrefixedSecret(RegistrationTokenPrefix)
+}
+
+// ParseRegistrationToken splits an app registration token into its prefix and the hash of its secret part
+func ParseRegistrationToken(token string) (prefix string, secretHash []byte, ok bool) {
+	return parsePrefixedSecret(RegistrationTokenPrefix, token)
+}
+
+func generatePrefixedSecret(kind string) (value, prefix string, secretHash []byte, err error) {
+	id, err := GenerateRandomBytes(6)
+	if err != nil {
+		return "", "", nil, err
+	}
+	secret, err := GenerateRandomBytes(32)
+	if err != nil {
+		return "", "", nil, err
+	}
+	prefix = kind + "_" + hex.EncodeToString(id)
+	secretHex := hex.EncodeToString(secret)
+	return prefix + "_" + secretHex, prefix, HashToken(secretHex), nil
+}
+
+func parsePrefixedSecret(kind, value string) (prefix string, secretHash []byte, ok bool) {
+	parts := strings.Split(value, "_")
+	if len(parts) != 3 || parts[0] != kind || parts[1] == "" || parts[2] == "" {
+		return "", nil, false
+	}
+	return parts[0] + "_" + parts[1], HashToken(parts[2]), true
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHCriticalOptions lists the certificate critical options OpenSSH understands (PROTOCOL.certkeys)
+var SSHCriticalOptions = map[string]bool{
+	"force-command":   true,
+	"source-address":  true,
+	"verify-required": true,
+}
+
+// SSHDefaultUserExtensions are the extensions ssh-keygen grants user certificates by default
+var SSHDefaultUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// SSHSigner converts the PKCS#8 material of an asymmetric key into an SSH signer
+func SSHSigner(material []byte) (ssh.Signer, error) {
+	privateKey, err := ParsePrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromSigner(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH signer: %w", err)
+	}
+	return signer, nil
+}
+
+// SSHCertificateRequest describes an OpenSSH certificate to sign
+type SSHCertificateRequest struct {
+	PublicKey       ssh.PublicKey
+	CertType        uint32 // ssh.UserCert or ssh.HostCert
+	KeyID           string // Certificate identity logged by sshd; the serial number is appended to it
+	Principals      []string
+	ValidBefore     time.Time
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+}
+
+// SignSSHCertificate signs an OpenSSH certificate for req.PublicKey with the CA key, valid from now
+// (backdated slightly for clock skew) until req.ValidBefore, with a random serial number. Its key ID is
+// req.KeyID followed by ":<serial>", so log lines can be traced to the one certificate.
+func SignSSHCertificate(ca ssh.Signer, req SSHCertificateRequest) (*ssh.Certificate, error) {
+	serialBytes := make([]byte, 8)
+	if _, err := rand.Read(serialBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	serial := binary.BigEndian.Uint64(serialBytes)
+
+	cert := &ssh.Certificate{
+		Key:             req.PublicKey,
+		Serial:          serial,
+		CertType:        req.CertType,
+		KeyId:           fmt.Sprintf("%s:%d", req.KeyID, serial),
+		ValidPrincipals: req.Principals,
+		ValidAfter:      uint64(time.Now().Add(-certificateBackdate).Unix()),
+		ValidBefore:     uint64(req.ValidBefore.Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: req.CriticalOptions,
+			Extensions:      req.Extensions,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("failed to sign SSH certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// certificateBackdate is subtracted from NotBefore so certificates are valid on hosts whose clocks lag a little
+const certificateBackdate = time.Minute
+
+// CertificateKeyUsages maps the key usage names accepted in certificate templates to their x509 bits
+var CertificateKeyUsages = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+}
+
+// CertificateExtKeyUsages maps the extended key usage names accepted in certificate templates to their x509 values
+var CertificateExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// CRLReasons maps the revocation reason names accepted by the API to their RFC 5280 CRLReason codes
+var CRLReasons = map[string]int{
+	"unspecified":            0,
+	"key_compromise":         1,
+	"ca_compromise":          2,
+	"affiliation_changed":    3,
+	"superseded":             4,
+	"cessation_of_operation": 5,
+	"privilege_withdrawn":    9,
+}
+
+// ParseKeyUsages combines key usage names into x509 key usage bits
+func ParseKeyUsages(names []string) (x509.KeyUsage, error) {
+	var usage x509.KeyUsage
+	for _, name := range names {
+		bit, ok := CertificateKeyUsages[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown key usage %q", name)
+		}
+		usage |= bit
+	}
+	return usage, nil
+}
+
+// ParseExtKeyUsages converts extended key usage names into x509 extended key usages
+func ParseExtKeyUsages(names []string) ([]x509.ExtKeyUsage, error) {
+	usages := make([]x509.ExtKeyUsage, 0, len(names))
+	for _, name := range names {
+		usage, ok := CertificateExtKeyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage %q", name)
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// NewCertificateSerialNumber generates a random positive 128-bit certificate serial number
+func NewCertificateSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
+// CreateCACertificate issues a CA certificate for caKey. If issuer is nil the certificate is a
+// self-signed root, otherwise an intermediate signed by issuerKey. maxPathLen < 0 leaves the
+// path length unconstrained; 0 stops the CA from issuing intermediates of its own.
+func CreateCACertificate(subject pkix.Name, caKey crypto.Signer, notAfter time.Time, maxPathLen int,
+	issuer *x509.Certificate, issuerKey crypto.Signer) ([]byte, error) {
+	serial, err := NewCertificateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-certificateBackdate),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLen == 0,
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, caKey.Public(), issuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	return der, nil
+}
+
+// IssueCertificate signs a leaf certificate for the public key and names of csr. The caller decides
+// which names are allowed. Only the common name of the CSR's subject is kept, since templates don't
+// constrain its other attributes (O, OU, ...), which relying parties may authorize on; the CSR's
+// extensions are not copied either.
+func IssueCertificate(csr *x509.CertificateRequest, dnsNames []string, keyUsage x509.KeyUsage, extKeyUsages []x509.ExtKeyUsage,
+	notAfter time.Time, issuer *x509.Certificate, issuerKey crypto.Signer) (der []byte, serial *big.Int, err error) {
+	serial, err = NewCertificateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:              dnsNames,
+		IPAddresses:           csr.IPAddresses,
+		URIs:                  csr.URIs,
+		NotBefore:             time.Now().Add(-certificateBackdate),
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           extKeyUsages,
+		BasicConstraintsValid: true,
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, template, issuer, csr.PublicKey, issuerKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+	return der, serial, nil
+}
+
+// CreateCRL signs a DER certificate revocation list listing the revoked entries
+func CreateCRL(number int64, revoked []x509.RevocationListEntry, nextUpdate time.Time,
+	issuer *x509.Certificate, issuerKey crypto.Signer) ([]byte, error) {
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: revoked,
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, issuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	return der, nil
+}
+
+// ParseCSRPEM parses a PEM-encoded certificate signing request and checks its self-signature
+func ParseCSRPEM(data string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("expected a PEM CERTIFICATE REQUEST block")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	return csr, nil
+}
+
+// EncodeCertificatePEM PEM-encodes a DER certificate
+func EncodeCertificatePEM(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// EncodePublicKeyPEM PEM-encodes a public key as a SubjectPublicKeyInfo ("PUBLIC KEY") block
+func EncodePublicKeyPEM(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
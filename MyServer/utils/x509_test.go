@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestCA returns a self-signed root CA certificate and its key
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := CreateCACertificate(pkix.Name{CommonName: "Test Root"}, key, time.Now().Add(24*time.Hour), 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newTestCSR returns a PEM CSR for a new key with the given subject and DNS SANs
+func newTestCSR(t *testing.T, subject pkix.Name, dnsNames []string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject, DNSNames: dnsNames}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestIssueCertificate(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	subject := pkix.Name{CommonName: "api.svc.example.com", Organization: []string{"Admins"}, OrganizationalUnit: []string{"mesh"}}
+	csr, err := ParseCSRPEM(newTestCSR(t, subject, []string{"api.svc.example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, serial, err := IssueCertificate(csr, csr.DNSNames, x509.KeyUsageDigitalSignature,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, time.Now().Add(time.Hour), ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.SerialNumber.Cmp(serial) != 0 {
+		t.Errorf("serial number = %s, want %s", cert.SerialNumber, serial)
+	}
+	if cert.Subject.CommonName != subject.CommonName {
+		t.Errorf("common name = %q, want %q", cert.Subject.CommonName, subject.CommonName)
+	}
+	if len(cert.Subject.Organization) != 0 || len(cert.Subject.OrganizationalUnit) != 0 {
+		t.Errorf("subject %s carries attributes from the CSR besides the common name", cert.Subject)
+	}
+	if cert.IsCA {
+		t.Error("leaf certificate is a CA")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = cert.Verify(x509.VerifyOptions{
+		DNSName:   "api.svc.example.com",
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Errorf("issued certificate doesn't verify: %v", err)
+	}
+}
+
+func TestParseCSRPEM(t *testing.T) {
+	valid := newTestCSR(t, pkix.Name{CommonName: "example.com"}, nil)
+	block, _ := pem.Decode([]byte(valid))
+	tampered := append([]byte{}, block.Bytes...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"not PEM", "not a CSR", true},
+		{"wrong block type", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes})), true},
+		{"bad signature", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: tampered})), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCSRPEM(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCSRPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateCRL(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	entries := []x509.RevocationListEntry{
+		{SerialNumber: big.NewInt(42), RevocationTime: revokedAt, ReasonCode: CRLReasons["key_compromise"]},
+	}
+
+	der, err := CreateCRL(7, entries, time.Now().Add(time.Hour), ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("CRL signature doesn't verify: %v", err)
+	}
+	if crl.Number.Int64() != 7 {
+		t.Errorf("CRL number = %s, want 7", crl.Number)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 {
+		t.Fatalf("CRL lists %d certificates, want 1", len(crl.RevokedCertificateEntries))
+	}
+	entry := crl.RevokedCertificateEntries[0]
+	if entry.SerialNumber.Int64() != 42 || !entry.RevocationTime.Equal(revokedAt) || entry.ReasonCode != 1 {
+		t.Errorf("CRL entry = serial %s, time %s, reason %d; want 42, %s, 1", entry.SerialNumber, entry.RevocationTime, entry.ReasonCode, revokedAt)
+	}
+}